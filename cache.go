@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// cacheFile is the local cache of a profile, holding the masked emails of
+// each synced account.
+type cacheFile struct {
+	// DefaultAccountID is the account synced without an explicit account id,
+	// so the cache can be used offline without one.
+	DefaultAccountID string                           `json:"defaultAccountId,omitempty"`
+	Accounts         map[string]*pkg.MaskedEmailCache `json:"accounts,omitempty"`
+}
+
+// cachePath returns the path of the cache file of a profile in the user's
+// cache directory, eg. $XDG_CACHE_HOME/maskedemail-cli/default.json.
+func cachePath(profileName string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, defaultAppname, profileName+".json"), nil
+}
+
+// loadCache reads the cache file. A missing file results in an empty cache.
+func loadCache(path string) (*cacheFile, error) {
+	c := &cacheFile{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// save writes the cache file, readable only by the current user.
+func (c *cacheFile) save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// account returns the cache of the account, or of the default account if
+// accID is empty. It returns nil if the account was never synced.
+func (c *cacheFile) account(accID string) *pkg.MaskedEmailCache {
+	if accID == "" {
+		accID = c.DefaultAccountID
+	}
+
+	return c.Accounts[accID]
+}
+
+// sessionAccountID returns the account id given with -accountid, or the
+// primary masked email account of the session.
+func sessionAccountID(session *pkg.SessionResource) string {
+	if *flagAccountID != "" {
+		return *flagAccountID
+	}
+
+	return session.DefaultAccountForCapability(pkg.MaskedEmailCapabilityURI)
+}
+
+// syncCache updates the cache of the account with the changes since the last
+// sync and saves it.
+func syncCache(ctx context.Context, client *pkg.Client, session *pkg.SessionResource, c *cacheFile, path string) (*pkg.MaskedEmailCache, *pkg.CacheSyncResult, error) {
+	accID := sessionAccountID(session)
+
+	accCache := c.account(accID)
+	if accCache == nil {
+		accCache = &pkg.MaskedEmailCache{}
+	}
+
+	result, err := client.SyncMaskedEmailCacheContext(ctx, session, accID, accCache)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.Accounts == nil {
+		c.Accounts = map[string]*pkg.MaskedEmailCache{}
+	}
+	c.Accounts[accCache.AccountID] = accCache
+	if *flagAccountID == "" {
+		c.DefaultAccountID = accCache.AccountID
+	}
+
+	if err := c.save(path); err != nil {
+		return nil, nil, fmt.Errorf("saving cache: %w", err)
+	}
+
+	return accCache, result, nil
+}
+
+// listMaskedEmails returns all masked emails of the account. Once an account
+// was synced, its cache is updated incrementally and used instead of listing
+// all masked emails, or used as-is with -offline.
+func listMaskedEmails(ctx context.Context, client *pkg.Client, includeDeleted bool) ([]*pkg.MaskedEmail, error) {
+	path, err := cachePath(activeProfileName)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := loadCache(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if *flagOffline {
+		accCache := c.account(*flagAccountID)
+		if accCache == nil {
+			return nil, fmt.Errorf("no cached masked emails, run %s first", actionTypeSync)
+		}
+
+		return accCache.List(includeDeleted), nil
+	}
+
+	session, err := client.SessionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.account(sessionAccountID(session)) == nil {
+		return client.GetAllMaskedEmailsContext(ctx, session, *flagAccountID, includeDeleted)
+	}
+
+	accCache, _, err := syncCache(ctx, client, session, c, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return accCache.List(includeDeleted), nil
+}
+
+// lookupMaskedEmailIDs is like Client.LookupMaskedEmailIDsContext, but
+// resolves addresses from the cache where possible without listing all
+// masked emails.
+func lookupMaskedEmailIDs(ctx context.Context, client *pkg.Client, session *pkg.SessionResource, targets []string) ([]string, error) {
+	ids := make([]string, len(targets))
+	copy(ids, targets)
+
+	if path, err := cachePath(activeProfileName); err == nil {
+		if c, err := loadCache(path); err == nil {
+			if accCache := c.account(sessionAccountID(session)); accCache != nil {
+				for i, target := range targets {
+					if email, ok := accCache.Lookup(target); ok {
+						ids[i] = email.ID
+					}
+				}
+			}
+		}
+	}
+
+	return client.LookupMaskedEmailIDsContext(ctx, session, *flagAccountID, ids)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// clipboardCommands lists the commands tried in order to write to the
+// clipboard, by platform.
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbcopy"}},
+	"windows": {{"clip.exe"}},
+	"linux": {
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+		{"xsel", "--clipboard", "--input"},
+		// WSL
+		{"clip.exe"},
+	},
+}
+
+var errNoClipboard = errors.New("no clipboard command found, install wl-copy, xclip or xsel")
+
+// copyToClipboard writes text to the system clipboard with the first
+// available clipboard command. Unknown platforms try the linux commands.
+func copyToClipboard(text string) error {
+	commands, ok := clipboardCommands[runtime.GOOS]
+	if !ok {
+		commands = clipboardCommands["linux"]
+	}
+
+	for _, args := range commands {
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+
+		cmd := exec.Command(path, args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+
+	return errNoClipboard
+}
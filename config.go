@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envConfigVarName  string = "MASKEDEMAIL_CONFIG"
+	envProfileVarName string = "MASKEDEMAIL_PROFILE"
+
+	defaultProfileName string = "default"
+	configFileName     string = "config.yaml"
+
+	// redacted replaces secrets when showing the config
+	redactedValue string = "********"
+)
+
+// config is the persistent configuration file, holding named profiles for
+// different accounts.
+//
+//	default_profile: personal
+//	profiles:
+//	  personal:
+//	    token_command: pass show fastmail
+//	  work:
+//	    keyring: true
+//	    account_id: u1234
+//	    appname: work-laptop
+//	    description: "created {{.Date}} for {{.Domain}}"
+type config struct {
+	DefaultProfile string              `yaml:"default_profile,omitempty"`
+	Profiles       map[string]*profile `yaml:"profiles,omitempty"`
+}
+
+// profile holds the settings for a single account.
+type profile struct {
+	// Token is the API token.
+	Token string `yaml:"token,omitempty"`
+	// TokenEnv is the name of an environment variable holding the API token.
+	TokenEnv string `yaml:"token_env,omitempty"`
+	// TokenFile is the path of a file holding the API token.
+	TokenFile string `yaml:"token_file,omitempty"`
+	// TokenCommand is a shell command printing the API token.
+	TokenCommand string `yaml:"token_command,omitempty"`
+	// Keyring is set if the API token is stored in the system keyring.
+	Keyring bool `yaml:"keyring,omitempty"`
+	// OAuthClientID and OAuthTokenURL are set by `login -oauth`, in which case
+	// the stored token is an OAuth token that is refreshed when it expires.
+	OAuthClientID string `yaml:"oauth_client_id,omitempty"`
+	OAuthTokenURL string `yaml:"oauth_token_url,omitempty"`
+
+	AccountID string `yaml:"account_id,omitempty"`
+	AppName   string `yaml:"appname,omitempty"`
+	Endpoint  string `yaml:"endpoint,omitempty"`
+
+	// Domain and Description are templates for the defaults of the create
+	// command, see createTemplateData.
+	Domain      string `yaml:"domain,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// profileKeys lists the keys that can be read and written with the config
+// command, in display order.
+var profileKeys = []string{"token", "token_env", "token_file", "token_command", "oauth_client_id", "oauth_token_url", "account_id", "appname", "endpoint", "domain", "description"}
+
+// secretProfileKeys are redacted when showing the config.
+var secretProfileKeys = map[string]bool{"token": true}
+
+// field returns a pointer to the profile value for the given key.
+func (p *profile) field(key string) (*string, error) {
+	switch key {
+	case "token":
+		return &p.Token, nil
+	case "token_env":
+		return &p.TokenEnv, nil
+	case "token_file":
+		return &p.TokenFile, nil
+	case "token_command":
+		return &p.TokenCommand, nil
+	case "oauth_client_id":
+		return &p.OAuthClientID, nil
+	case "oauth_token_url":
+		return &p.OAuthTokenURL, nil
+	case "account_id":
+		return &p.AccountID, nil
+	case "appname":
+		return &p.AppName, nil
+	case "endpoint":
+		return &p.Endpoint, nil
+	case "domain":
+		return &p.Domain, nil
+	case "description":
+		return &p.Description, nil
+	default:
+		return nil, fmt.Errorf("unknown config key %q (valid: %s)", key, strings.Join(profileKeys, ", "))
+	}
+}
+
+// token returns the API token of the profile from its configured source.
+func (p *profile) token(profileName string) (string, error) {
+	switch {
+	case p.Token != "":
+		return p.Token, nil
+	case p.TokenEnv != "":
+		return os.Getenv(p.TokenEnv), nil
+	case p.TokenFile != "":
+		return readTokenFile(p.TokenFile)
+	case p.TokenCommand != "":
+		return runTokenCommand(p.TokenCommand)
+	case p.Keyring:
+		return keyringGet(profileName)
+	default:
+		return "", nil
+	}
+}
+
+// tokenStore returns where the token of the profile is stored by login, or an
+// empty string if it's read from a source that can't be written to.
+func (p *profile) tokenStore() string {
+	switch {
+	case p.Token != "":
+		return tokenStoreConfig
+	case p.TokenEnv != "":
+		return ""
+	case p.TokenFile != "":
+		return tokenStoreFile
+	case p.TokenCommand != "":
+		return ""
+	case p.Keyring:
+		return tokenStoreKeyring
+	default:
+		return ""
+	}
+}
+
+// createTemplateData is passed to the domain and description templates of a
+// profile when creating masked emails.
+type createTemplateData struct {
+	// Domain is the domain of the new masked email, empty when rendering the
+	// domain template itself.
+	Domain string
+	// Prefix is the requested email prefix.
+	Prefix string
+	// Profile is the name of the active profile.
+	Profile string
+	// Date is the current date as YYYY-MM-DD.
+	Date string
+}
+
+// renderTemplate renders one of the profile's create templates.
+func renderTemplate(name, text string, data createTemplateData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s template: %w", name, err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("rendering %s template: %w", name, err)
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func newCreateTemplateData(profileName, domain, prefix string) createTemplateData {
+	return createTemplateData{
+		Domain:  domain,
+		Prefix:  prefix,
+		Profile: profileName,
+		Date:    time.Now().Format("2006-01-02"),
+	}
+}
+
+// configPath returns the path of the config file: the given path, the
+// MASKEDEMAIL_CONFIG env, or config.yaml in the XDG config directory.
+func configPath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+
+	if path = os.Getenv(envConfigVarName); path != "" {
+		return path, nil
+	}
+
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, defaultAppname, configFileName), nil
+}
+
+// loadConfig reads the config file. A missing file results in an empty
+// config.
+func loadConfig(path string) (*config, error) {
+	cfg := &config{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// save writes the config file, readable only by the current user as it may
+// contain tokens.
+func (c *config) save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// profileName returns the name of the selected profile: the given name, the
+// MASKEDEMAIL_PROFILE env, the configured default profile or "default".
+func (c *config) profileName(name string) string {
+	if name != "" {
+		return name
+	}
+
+	if name = os.Getenv(envProfileVarName); name != "" {
+		return name
+	}
+
+	if c.DefaultProfile != "" {
+		return c.DefaultProfile
+	}
+
+	return defaultProfileName
+}
+
+// profile returns the profile with the given name. Only the implicit default
+// profile may be missing, in which case an empty profile is returned.
+func (c *config) profile(name string, explicit bool) (*profile, error) {
+	if p, ok := c.Profiles[name]; ok && p != nil {
+		return p, nil
+	}
+
+	if explicit {
+		return nil, fmt.Errorf("profile %q not found in config", name)
+	}
+
+	return &profile{}, nil
+}
+
+// ensureProfile returns the profile with the given name, adding it if needed.
+func (c *config) ensureProfile(name string) *profile {
+	if c.Profiles == nil {
+		c.Profiles = map[string]*profile{}
+	}
+
+	if c.Profiles[name] == nil {
+		c.Profiles[name] = &profile{}
+	}
+
+	return c.Profiles[name]
+}
+
+// redacted returns a copy of the config with secrets replaced.
+func (c *config) redacted() *config {
+	out := &config{DefaultProfile: c.DefaultProfile, Profiles: map[string]*profile{}}
+	for name, p := range c.Profiles {
+		if p == nil {
+			continue
+		}
+
+		cp := *p
+		for key := range secretProfileKeys {
+			if v, _ := cp.field(key); *v != "" {
+				*v = redactedValue
+			}
+		}
+		out.Profiles[name] = &cp
+	}
+
+	return out
+}
+
+// profileNames returns the names of all profiles, sorted.
+func (c *config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// runConfig implements the config command:
+//
+//	config path
+//	config show
+//	config profiles
+//	config use <profile>
+//	config get <key>
+//	config set <key> <value>
+//	config unset <key>
+func runConfig(cfg *config, path string, profileName string, cmdArgs []string) error {
+	if len(cmdArgs) == 0 {
+		return errors.New("missing config subcommand (path|show|profiles|use|get|set|unset)")
+	}
+
+	sub, rest := cmdArgs[0], cmdArgs[1:]
+	wantArgs := map[string]int{"path": 0, "show": 0, "profiles": 0, "use": 1, "get": 1, "set": 2, "unset": 1}
+	n, ok := wantArgs[sub]
+	if !ok {
+		return fmt.Errorf("unknown config subcommand %q", sub)
+	}
+	if len(rest) != n {
+		return fmt.Errorf("config %s expects %d argument(s)", sub, n)
+	}
+
+	switch sub {
+	case "path":
+		fmt.Println(path)
+
+	case "show":
+		data, err := yaml.Marshal(cfg.redacted())
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+
+	case "profiles":
+		for _, name := range cfg.profileNames() {
+			marker := " "
+			if name == profileName {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+
+	case "use":
+		if _, ok := cfg.Profiles[rest[0]]; !ok {
+			return fmt.Errorf("profile %q not found in config", rest[0])
+		}
+		cfg.DefaultProfile = rest[0]
+		return cfg.save(path)
+
+	case "get":
+		p, err := cfg.profile(profileName, false)
+		if err != nil {
+			return err
+		}
+		v, err := p.field(rest[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(*v)
+
+	case "set", "unset":
+		v, err := cfg.ensureProfile(profileName).field(rest[0])
+		if err != nil {
+			return err
+		}
+
+		if sub == "set" {
+			*v = rest[1]
+		} else {
+			*v = ""
+		}
+
+		return cfg.save(path)
+	}
+
+	return nil
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// importFields are the masked email fields import can update, named like in
+// exported files.
+var importFields = []string{"forDomain", "description", "state", "url"}
+
+// runExport writes all masked emails, including deleted ones, to path or
+// stdout. Exports are json unless -output selects another machine readable
+// format.
+func runExport(ctx context.Context, client *pkg.Client, path string, output string) error {
+	if output == outputTable {
+		output = outputJSON
+	}
+
+	w := io.Writer(os.Stdout)
+	if path != "" && path != "-" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	p, err := newPrinter(w, output, "")
+	if err != nil {
+		return err
+	}
+
+	session, err := client.SessionContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	emails, err := client.GetAllMaskedEmailsContext(ctx, session, *flagAccountID, true)
+	if err != nil {
+		return err
+	}
+
+	if err := p.print(maskedEmailRecords(emails), maskedEmailColumns(p, true), nil); err != nil {
+		return err
+	}
+
+	if w != os.Stdout {
+		log.Printf("exported %d masked emails to %s", len(emails), path)
+	}
+
+	return nil
+}
+
+// importRecord is a masked email read from an import file, keyed by field
+// name. Only the fields present in the file are set.
+type importRecord map[string]string
+
+// readImportFile reads masked emails from a json file as written by export,
+// or from a csv or tsv file with a header row. Files are tsv if the header row
+// contains a tab, so stdin and files without a .tsv extension work too. "-"
+// reads stdin.
+func readImportFile(path string) ([]importRecord, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var raw []map[string]interface{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+
+		records := make([]importRecord, len(raw))
+		for i, obj := range raw {
+			records[i] = importRecord{}
+			for key, v := range obj {
+				switch v := v.(type) {
+				case nil:
+					records[i][key] = ""
+				case string:
+					records[i][key] = v
+				default:
+					records[i][key] = fmt.Sprint(v)
+				}
+			}
+		}
+
+		return records, nil
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	headerRow, _, _ := bytes.Cut(data, []byte("\n"))
+	if strings.EqualFold(filepath.Ext(path), ".tsv") || bytes.ContainsRune(headerRow, '\t') {
+		r.Comma = '\t'
+	}
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%s: missing header row", path)
+	}
+
+	header := rows[0]
+	records := make([]importRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := importRecord{}
+		for i, field := range header {
+			record[strings.TrimSpace(field)] = row[i]
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// importChange is a single field changed by import.
+type importChange struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+func importChangeColumn(header, field string, value func(c *importChange) string) column {
+	return column{
+		header: header,
+		field:  field,
+		value: func(record interface{}) string {
+			return value(record.(*importChange))
+		},
+	}
+}
+
+var importChangeColumns = []column{
+	importChangeColumn("ID", "id", func(c *importChange) string { return c.ID }),
+	importChangeColumn("Masked Email", "email", func(c *importChange) string { return c.Email }),
+	importChangeColumn("Field", "field", func(c *importChange) string { return c.Field }),
+	importChangeColumn("From", "from", func(c *importChange) string { return c.From }),
+	importChangeColumn("To", "to", func(c *importChange) string { return c.To }),
+}
+
+// planImport compares the records against the current masked emails, matched
+// by ID or else by address, and returns the changed fields with the updates to
+// apply. Records that can't be applied, including further records of a masked
+// email that is already in the file, are returned as errors.
+func planImport(records []importRecord, current []*pkg.MaskedEmail, fields []string) ([]*importChange, map[string][]pkg.UpdateOption, []error) {
+	byID := make(map[string]*pkg.MaskedEmail, len(current))
+	byEmail := make(map[string]*pkg.MaskedEmail, len(current))
+	for _, email := range current {
+		byID[email.ID] = email
+		byEmail[strings.ToLower(email.Email)] = email
+	}
+
+	var changes []*importChange
+	var problems []error
+	updates := map[string][]pkg.UpdateOption{}
+	seen := map[string]int{}
+
+	for i, record := range records {
+		email, ok := byID[strings.TrimSpace(record["id"])]
+		if !ok {
+			email, ok = byEmail[strings.ToLower(strings.TrimSpace(record["email"]))]
+		}
+		if !ok {
+			problems = append(problems, fmt.Errorf("record %d: masked email %s not found", i+1, firstNonEmpty(record["email"], record["id"])))
+			continue
+		}
+		if first, ok := seen[email.ID]; ok {
+			problems = append(problems, fmt.Errorf("record %d: masked email %s is already in record %d", i+1, email.Email, first))
+			continue
+		}
+		seen[email.ID] = i + 1
+
+		for _, field := range fields {
+			want, ok := record[field]
+			if !ok {
+				continue
+			}
+			want = strings.TrimSpace(want)
+
+			var have string
+			var opt pkg.UpdateOption
+			switch field {
+			case "forDomain":
+				have, opt = strings.TrimSpace(email.Domain), pkg.WithUpdateDomain(want)
+			case "description":
+				have, opt = strings.TrimSpace(email.Description), pkg.WithUpdateDescription(want)
+			case "state":
+				have, opt = string(email.State), pkg.WithUpdateState(pkg.MaskedEmailState(want))
+			case "url":
+				have, opt = strings.TrimSpace(email.URL), pkg.WithUpdateURL(want)
+			}
+
+			if want == have {
+				continue
+			}
+
+			if field == "state" && want != string(pkg.MaskedEmailStateEnabled) && want != string(pkg.MaskedEmailStateDisabled) && want != string(pkg.MaskedEmailStateDeleted) {
+				problems = append(problems, fmt.Errorf("record %d: can't change state of %s to %q", i+1, email.Email, want))
+				continue
+			}
+
+			changes = append(changes, &importChange{ID: email.ID, Email: email.Email, Field: field, From: have, To: want})
+			updates[email.ID] = append(updates[email.ID], opt)
+		}
+	}
+
+	return changes, updates, problems
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// parseImportFields parses the comma separated -fields flag.
+func parseImportFields(s string) ([]string, error) {
+	var fields []string
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if strings.EqualFold(field, "domain") {
+			field = "forDomain"
+		}
+
+		valid := false
+		for _, f := range importFields {
+			valid = valid || f == field
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown import field %q (valid: %s)", field, strings.Join(importFields, ","))
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// runImport reconciles the masked emails of the account with the file,
+// printing the changes and applying them unless dryRun is set.
+func runImport(ctx context.Context, client *pkg.Client, path string, fieldList string, dryRun bool) error {
+	fields, err := parseImportFields(fieldList)
+	if err != nil {
+		return err
+	}
+
+	records, err := readImportFile(path)
+	if err != nil {
+		return err
+	}
+
+	session, err := client.SessionContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	current, err := client.GetAllMaskedEmailsContext(ctx, session, *flagAccountID, true)
+	if err != nil {
+		return err
+	}
+
+	changes, updates, problems := planImport(records, current, fields)
+	for _, problem := range problems {
+		log.Println(problem)
+	}
+
+	if dryRun || len(updates) == 0 {
+		err = out.print(importChangeRecords(changes), importChangeColumns, func(w io.Writer) {
+			for _, c := range changes {
+				fmt.Fprintf(w, "%s %s: %s %q -> %q\n", c.ID, c.Email, c.Field, c.From, c.To)
+			}
+			fmt.Fprintf(w, "%d change(s) to %d masked email(s)", len(changes), len(updates))
+			if dryRun {
+				fmt.Fprint(w, " (dry run)")
+			}
+			fmt.Fprintln(w)
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(problems) > 0 {
+			return fmt.Errorf("%d record(s) can't be imported", len(problems))
+		}
+		return nil
+	}
+
+	res, err := client.UpdateMaskedEmailsContext(ctx, session, *flagAccountID, updates)
+	var setErrs pkg.SetErrors
+	if err != nil && !errors.As(err, &setErrs) {
+		return err
+	}
+
+	applied := []*importChange{}
+	for _, c := range changes {
+		if _, ok := res.Updated[c.ID]; ok {
+			applied = append(applied, c)
+		}
+	}
+
+	err = out.print(importChangeRecords(applied), importChangeColumns, func(w io.Writer) {
+		for _, c := range applied {
+			fmt.Fprintf(w, "%s %s: %s %q -> %q\n", c.ID, c.Email, c.Field, c.From, c.To)
+		}
+		fmt.Fprintf(w, "updated %d masked email(s)\n", len(updates)-len(setErrs))
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(setErrs) > 0 {
+		return setErrs
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d record(s) can't be imported", len(problems))
+	}
+
+	return nil
+}
+
+func importChangeRecords(changes []*importChange) []interface{} {
+	records := make([]interface{}, len(changes))
+	for i, c := range changes {
+		records[i] = c
+	}
+
+	return records
+}
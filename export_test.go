@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+func TestReadImportFile(t *testing.T) {
+	want := []importRecord{
+		{"email": "a@example.com", "description": "a, b"},
+		{"email": "b@example.com", "description": ""},
+	}
+
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{name: "csv", file: "backup.csv", data: "email,description\na@example.com,\"a, b\"\nb@example.com,\n"},
+		{name: "tsv", file: "backup.tsv", data: "email\tdescription\na@example.com\ta, b\nb@example.com\t\n"},
+		{name: "tsv without extension", file: "backup", data: "email\tdescription\na@example.com\ta, b\nb@example.com\t\n"},
+		{name: "tsv as txt", file: "backup.txt", data: "email\tdescription\r\na@example.com\ta, b\r\nb@example.com\t\r\n"},
+		{name: "json", file: "backup.json", data: `[{"email": "a@example.com", "description": "a, b"}, {"email": "b@example.com", "description": null}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readImportFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	current := []*pkg.MaskedEmail{
+		{ID: "masked-1", Email: "a@example.com", Domain: "github.com ", URL: "https://github.com ", State: pkg.MaskedEmailStateEnabled},
+		{ID: "masked-2", Email: "b@example.com", Description: "old", State: pkg.MaskedEmailStateDisabled},
+	}
+
+	records := []importRecord{
+		{"email": "A@example.com", "forDomain": "github.com", "url": "https://github.com"},
+		{"id": "masked-2", "description": "new", "state": "enabled"},
+		{"email": "b@example.com", "description": "other"},
+		{"email": "c@example.com", "description": "missing"},
+		{"id": "masked-1", "state": "pending"},
+	}
+
+	changes, updates, problems := planImport(records, current, importFields)
+
+	wantChanges := []*importChange{
+		{ID: "masked-2", Email: "b@example.com", Field: "description", From: "old", To: "new"},
+		{ID: "masked-2", Email: "b@example.com", Field: "state", From: "disabled", To: "enabled"},
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("got changes %+v, want %+v", changes, wantChanges)
+	}
+
+	if len(updates) != 1 || len(updates["masked-2"]) != 2 {
+		t.Errorf("got updates %v, want 2 for masked-2", updates)
+	}
+
+	wantProblems := []string{
+		"record 3: masked email b@example.com is already in record 2",
+		"record 4: masked email c@example.com not found",
+		"record 5: masked email a@example.com is already in record 1",
+	}
+	var gotProblems []string
+	for _, problem := range problems {
+		gotProblems = append(gotProblems, problem.Error())
+	}
+	if strings.Join(gotProblems, "\n") != strings.Join(wantProblems, "\n") {
+		t.Errorf("got problems\n%s\nwant\n%s", strings.Join(gotProblems, "\n"), strings.Join(wantProblems, "\n"))
+	}
+}
@@ -0,0 +1,247 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// The keyring is accessed through the freedesktop Secret Service D-Bus API,
+// as provided by GNOME Keyring, KeePassXC and KWallet.
+//
+// https://specifications.freedesktop.org/secret-service/latest/
+const (
+	secretServiceName       = "org.freedesktop.secrets"
+	secretServicePath       = "/org/freedesktop/secrets"
+	secretServiceIface      = "org.freedesktop.Secret.Service"
+	secretItemIface         = "org.freedesktop.Secret.Item"
+	secretCollectionIface   = "org.freedesktop.Secret.Collection"
+	secretPromptIface       = "org.freedesktop.Secret.Prompt"
+	secretDefaultCollection = "/org/freedesktop/secrets/aliases/default"
+)
+
+// secret is the Secret struct of the Secret Service API.
+type secret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// secretService is an open session with the Secret Service.
+type secretService struct {
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+}
+
+func openSecretService() (*secretService, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("connecting to session bus: %w", err)
+	}
+
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err = conn.Object(secretServiceName, secretServicePath).
+		Call(secretServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).
+		Store(&output, &session)
+	if err != nil {
+		return nil, fmt.Errorf("opening secret service session: %w", err)
+	}
+
+	return &secretService{conn: conn, session: session}, nil
+}
+
+func (s *secretService) close() {
+	s.conn.Object(secretServiceName, s.session).Call("org.freedesktop.Secret.Session.Close", 0)
+}
+
+// attributes identify the item of a profile in the keyring.
+func keyringAttributes(profileName string) map[string]string {
+	return map[string]string{
+		"service": defaultAppname,
+		"profile": profileName,
+	}
+}
+
+// search returns the unlocked items matching the attributes, unlocking them
+// if necessary.
+func (s *secretService) search(attrs map[string]string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	err := s.conn.Object(secretServiceName, secretServicePath).
+		Call(secretServiceIface+".SearchItems", 0, attrs).
+		Store(&unlocked, &locked)
+	if err != nil {
+		return nil, fmt.Errorf("searching keyring: %w", err)
+	}
+
+	if len(locked) > 0 {
+		if err := s.unlock(locked); err != nil {
+			return nil, err
+		}
+		unlocked = append(unlocked, locked...)
+	}
+
+	return unlocked, nil
+}
+
+// unlock unlocks the given items or collections, prompting the user if the
+// service asks to.
+func (s *secretService) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	err := s.conn.Object(secretServiceName, secretServicePath).
+		Call(secretServiceIface+".Unlock", 0, objects).
+		Store(&unlocked, &prompt)
+	if err != nil {
+		return fmt.Errorf("unlocking keyring: %w", err)
+	}
+
+	return s.prompt(prompt)
+}
+
+// prompt runs a Secret Service prompt and waits for it to complete.
+func (s *secretService) prompt(prompt dbus.ObjectPath) error {
+	if prompt == "/" || prompt == "" {
+		return nil
+	}
+
+	signals := make(chan *dbus.Signal, 1)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(secretPromptIface),
+	}
+	if err := s.conn.AddMatchSignal(match...); err != nil {
+		return err
+	}
+	defer s.conn.RemoveMatchSignal(match...)
+
+	if err := s.conn.Object(secretServiceName, prompt).Call(secretPromptIface+".Prompt", 0, "").Err; err != nil {
+		return fmt.Errorf("prompting to unlock keyring: %w", err)
+	}
+
+	for sig := range signals {
+		if sig.Path != prompt || sig.Name != secretPromptIface+".Completed" {
+			continue
+		}
+
+		// the dismissed flag may be missing from non-conforming services
+		if len(sig.Body) > 0 {
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return errors.New("keyring prompt dismissed")
+			}
+		}
+
+		return nil
+	}
+
+	return errors.New("keyring prompt did not complete")
+}
+
+// keyringGet returns the token stored for the profile.
+func keyringGet(profileName string) (string, error) {
+	s, err := openSecretService()
+	if err != nil {
+		return "", err
+	}
+	defer s.close()
+
+	items, err := s.search(keyringAttributes(profileName))
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "", fmt.Errorf("no token for profile %q in keyring", profileName)
+	}
+
+	var sec secret
+	err = s.conn.Object(secretServiceName, items[0]).
+		Call(secretItemIface+".GetSecret", 0, s.session).
+		Store(&sec)
+	if err != nil {
+		return "", fmt.Errorf("reading token from keyring: %w", err)
+	}
+
+	return string(sec.Value), nil
+}
+
+// keyringSet stores the token for the profile, replacing an existing one.
+func keyringSet(profileName string, token string) error {
+	s, err := openSecretService()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	collection := dbus.ObjectPath(secretDefaultCollection)
+	if err := s.unlock([]dbus.ObjectPath{collection}); err != nil {
+		return err
+	}
+
+	props := map[string]dbus.Variant{
+		secretItemIface + ".Label":      dbus.MakeVariant(fmt.Sprintf("%s token (%s)", defaultAppname, profileName)),
+		secretItemIface + ".Attributes": dbus.MakeVariant(keyringAttributes(profileName)),
+	}
+	sec := secret{
+		Session:     s.session,
+		Value:       []byte(token),
+		ContentType: "text/plain",
+	}
+
+	var item, prompt dbus.ObjectPath
+	err = s.conn.Object(secretServiceName, collection).
+		Call(secretCollectionIface+".CreateItem", 0, props, sec, true).
+		Store(&item, &prompt)
+	if err != nil {
+		return fmt.Errorf("storing token in keyring: %w", err)
+	}
+
+	return s.prompt(prompt)
+}
+
+// keyringDelete removes the token stored for the profile, if any.
+func keyringDelete(profileName string) error {
+	s, err := openSecretService()
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	items, err := s.search(keyringAttributes(profileName))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		err := s.conn.Object(secretServiceName, item).
+			Call(secretItemIface+".Delete", 0).
+			Store(&prompt)
+		if err != nil {
+			return fmt.Errorf("deleting token from keyring: %w", err)
+		}
+
+		if err := s.prompt(prompt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyringAvailable reports whether a Secret Service is reachable.
+func keyringAvailable() bool {
+	s, err := openSecretService()
+	if err != nil {
+		return false
+	}
+	s.close()
+
+	return true
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+var errKeyringUnsupported = errors.New("keyring storage is only supported on linux, use a token file or token command instead")
+
+func keyringGet(profileName string) (string, error) {
+	return "", errKeyringUnsupported
+}
+
+func keyringSet(profileName string, token string) error {
+	return errKeyringUnsupported
+}
+
+func keyringDelete(profileName string) error {
+	return errKeyringUnsupported
+}
+
+func keyringAvailable() bool {
+	return false
+}
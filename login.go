@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+	"golang.org/x/term"
+)
+
+const (
+	tokenStoreKeyring = "keyring"
+	tokenStoreFile    = "file"
+	tokenStoreConfig  = "config"
+)
+
+var tokenStores = []string{tokenStoreKeyring, tokenStoreFile, tokenStoreConfig}
+
+// explicitToken returns the token from the token source flags, if any.
+func explicitToken() (string, error) {
+	switch {
+	case *flagTokenFile != "":
+		return readTokenFile(*flagTokenFile)
+	case *flagTokenCommand != "":
+		return runTokenCommand(*flagTokenCommand)
+	case *flagTokenStdin:
+		return readToken(os.Stdin)
+	default:
+		return "", nil
+	}
+}
+
+// promptToken asks for the token on the terminal without echoing it, or reads
+// it from stdin if that isn't a terminal.
+func promptToken() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readToken(os.Stdin)
+	}
+
+	fmt.Fprint(os.Stderr, "Fastmail API token: ")
+	token, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	return readToken(bytes.NewReader(token))
+}
+
+// runLogin validates the token against the API and stores it for the active
+// profile.
+func runLogin(ctx context.Context, token string, store string) error {
+	var err error
+	if token == "" {
+		token, err = promptToken()
+		if err != nil {
+			return err
+		}
+	}
+
+	session, err := newClient(pkg.BearerToken(token)).SessionContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	store, err = storeLogin(store, token, nil)
+	if err != nil {
+		return err
+	}
+
+	printLoggedIn(session, store)
+	return nil
+}
+
+// storeLogin stores the token for the active profile, replacing any other
+// configured token source, and returns the store used. oauth is set if the
+// token is an OAuth token.
+func storeLogin(store string, token string, oauth *pkg.OAuthConfig) (string, error) {
+	if store == "" {
+		store = tokenStoreFile
+		if keyringAvailable() {
+			store = tokenStoreKeyring
+		}
+	}
+
+	p := cfg.ensureProfile(activeProfileName)
+	switch store {
+	case tokenStoreKeyring:
+		if err := keyringSet(activeProfileName, token); err != nil {
+			return "", err
+		}
+
+	case tokenStoreFile:
+		path := managedTokenFile(cfgPath, activeProfileName)
+		if err := writeTokenFile(path, token); err != nil {
+			return "", err
+		}
+		p.TokenFile = path
+
+	case tokenStoreConfig:
+		p.Token = token
+
+	default:
+		return "", fmt.Errorf("unknown token store %q", store)
+	}
+
+	// the new token replaces any other configured source
+	p.Keyring = store == tokenStoreKeyring
+	if store != tokenStoreFile {
+		p.TokenFile = ""
+	}
+	if store != tokenStoreConfig {
+		p.Token = ""
+	}
+	p.TokenEnv = ""
+	p.TokenCommand = ""
+
+	p.OAuthClientID = ""
+	p.OAuthTokenURL = ""
+	if oauth != nil {
+		p.OAuthClientID = oauth.ClientID
+		p.OAuthTokenURL = oauth.TokenEndpoint
+	}
+
+	if err := cfg.save(cfgPath); err != nil {
+		return "", err
+	}
+
+	return store, nil
+}
+
+func printLoggedIn(session *pkg.SessionResource, store string) {
+	for _, acc := range session.Accounts {
+		fmt.Printf("logged in as %s (profile: %s, stored in: %s)\n", acc.Name, activeProfileName, store)
+		break
+	}
+}
+
+// runLogout removes the stored token of the active profile. Token files not
+// created by login are only unset in the config, not deleted.
+func runLogout() error {
+	p, ok := cfg.Profiles[activeProfileName]
+	if !ok || p == nil {
+		return fmt.Errorf("profile %q not found in config", activeProfileName)
+	}
+
+	if p.Keyring {
+		if err := keyringDelete(activeProfileName); err != nil {
+			return err
+		}
+	}
+
+	if managed := managedTokenFile(cfgPath, activeProfileName); p.TokenFile == managed {
+		if err := os.Remove(managed); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	p.Token = ""
+	p.TokenFile = ""
+	p.Keyring = false
+	p.OAuthClientID = ""
+	p.OAuthTokenURL = ""
+
+	if err := cfg.save(cfgPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("logged out (profile: %s)\n", activeProfileName)
+	return nil
+}
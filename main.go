@@ -1,13 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
-	"text/tabwriter"
+	"syscall"
+	"time"
 
 	"github.com/dvcrn/maskedemail-cli/pkg"
 )
@@ -20,17 +28,58 @@ const (
 	envTokenVarName     string = "MASKEDEMAIL_TOKEN"
 	envAppVarName       string = "MASKEDEMAIL_APPNAME"
 	envAccountIdVarName string = "MASKEDEMAIL_ACCOUNTID"
+	envEndpointVarName  string = "MASKEDEMAIL_ENDPOINT"
 
 	flagNameToken     string = "token"
+	flagNameTokenFile string = "token-file"
+	flagNameTokenCmd  string = "token-command"
+	flagNameTokenIn   string = "token-stdin"
+	flagNameStore     string = "store"
 	flagNameAccountID string = "accountid"
+	flagNameEndpoint  string = "endpoint"
+	flagNameTimeout   string = "timeout"
+	flagNameOutput    string = "output"
+	flagNameFormat    string = "format"
+	flagNameConfig    string = "config"
+	flagNameProfile   string = "profile"
+	flagNameOffline   string = "offline"
+	flagNameUTC       string = "utc"
 
 	flagNameEmail         string = "email"
 	flagNameDomain        string = "domain"
 	flagNameDesc          string = "desc"
 	flagNamePrefix        string = "prefix"
+	flagNameURL           string = "url"
 	flagNameEnabled       string = "enabled"
 	flagNameShowDeleted   string = "show-deleted"
 	flagNameShowAllFields string = "all-fields"
+	flagNameStdin         string = "stdin"
+	flagNameState         string = "state"
+	flagNameCreatedBy     string = "created-by"
+	flagNameCreatedAfter  string = "created-after"
+	flagNameCreatedBefore string = "created-before"
+	flagNameMessageAfter  string = "last-message-after"
+	flagNameMessageBefore string = "last-message-before"
+	flagNameSort          string = "sort"
+	flagNameLimit         string = "limit"
+	flagNameOlderThan     string = "older-than"
+	flagNameConfirm       string = "confirm"
+	flagNameOAuth         string = "oauth"
+	flagNameDevice        string = "device"
+	flagNameOAuthIssuer   string = "oauth-issuer"
+	flagNameClientID      string = "client-id"
+	flagNameListen        string = "listen"
+	flagNameKeys          string = "keys"
+	flagNameRate          string = "rate"
+	flagNameAuditLog      string = "audit-log"
+	flagNameFile          string = "file"
+	flagNameDryRun        string = "dry-run"
+	flagNameFields        string = "fields"
+	flagNameReuse         string = "reuse"
+	flagNameReenable      string = "reenable"
+	flagNameSeed          string = "seed"
+	flagNameLatency       string = "latency"
+	flagNameFailRate      string = "fail-rate"
 
 	actionTypeUnknown = ""
 	actionTypeCreate  = "create"
@@ -41,6 +90,28 @@ const (
 	actionTypeUpdate  = "update"
 	actionTypeList    = "list"
 	actionTypeVersion = "version"
+	actionTypeConfirm = "confirm"
+	actionTypePending = "pending"
+	actionTypeConfig  = "config"
+	actionTypeLogin   = "login"
+	actionTypeLogout  = "logout"
+	actionTypeSync    = "sync"
+	actionTypeTUI     = "tui"
+	actionTypeServe   = "serve"
+	actionTypeExport  = "export"
+	actionTypeImport  = "import"
+	actionTypePlan    = "plan"
+	actionTypeApply   = "apply"
+	actionTypeMock    = "mock-server"
+)
+
+// exit codes, so scripts can tell failures apart
+const (
+	exitCodeError    = 1
+	exitCodeAuth     = 3
+	exitCodeHTTP     = 4
+	exitCodeJMAP     = 5
+	exitCodeCanceled = 130
 )
 
 // build info values get passed in from makefile via `-ldflags` argument to `go build`
@@ -53,30 +124,104 @@ var buildCommit string = "n/a"
 
 // default / highest level flags
 var flagAppname = flag.String("appname", os.Getenv(envAppVarName), "the appname to identify the creator (or "+envAppVarName+" env) (default: "+defaultAppname+")")
-var flagToken = flag.String(flagNameToken, "", "the token to authenticate with (or "+envTokenVarName+" env)")
+var flagToken = flag.String(flagNameToken, "", "the token to authenticate with (or "+envTokenVarName+" env), prefer one of the other token sources as this leaks into shell history")
+var flagTokenFile = flag.String(flagNameTokenFile, "", "read the token from this file, which must only be accessible by the current user")
+var flagTokenCommand = flag.String(flagNameTokenCmd, "", "read the token from the output of this shell command, eg. 'pass show fastmail'")
+var flagTokenStdin = flag.Bool(flagNameTokenIn, false, "read the token from the first line of stdin")
 var flagAccountID = flag.String(flagNameAccountID, os.Getenv(envAccountIdVarName), "fastmail account id (or "+envAccountIdVarName+" env)")
+var flagEndpoint = flag.String(flagNameEndpoint, os.Getenv(envEndpointVarName), "the JMAP session endpoint (or "+envEndpointVarName+" env) (default: "+pkg.DefaultSessionEndpoint+")")
+var flagOutput = flag.String(flagNameOutput, outputTable, "output format ("+strings.Join(outputFormats, "|")+")")
+var flagFormat = flag.String(flagNameFormat, "", "go template applied to each result, eg. '{{.Email}}' (overrides -"+flagNameOutput+")")
+var flagConfig = flag.String(flagNameConfig, "", "path to the config file (or "+envConfigVarName+" env) (default: $XDG_CONFIG_HOME/"+defaultAppname+"/"+configFileName+")")
+var flagProfile = flag.String(flagNameProfile, "", "the config profile to use (or "+envProfileVarName+" env) (default: the config's default_profile or \""+defaultProfileName+"\")")
+var flagOffline = flag.Bool(flagNameOffline, false, "list masked emails from the local cache without contacting the API (see the "+actionTypeSync+" command)")
+var flagUTC = flag.Bool(flagNameUTC, false, "show times in UTC instead of the local timezone")
+var flagTimeout = flag.Duration(flagNameTimeout, 30*time.Second, "timeout for each request to the API (0 to disable)")
 
 // flags for list command
 var listCmd = flag.NewFlagSet(actionTypeList, flag.ExitOnError)
 var flagShowDeleted = listCmd.Bool(flagNameShowDeleted, false, "show deleted masked emails (true|false) (default false)")
 var flagShowAllFields = listCmd.Bool(flagNameShowAllFields, false, "show all masked email fields (true|false) (default false)")
+var flagListState = listCmd.String(flagNameState, "", "only show masked emails in one of these comma separated states, eg. enabled,disabled")
+var flagListDomain = listCmd.String(flagNameDomain, "", "only show masked emails whose domain contains this, or matches it as glob (eg. '*.example.com')")
+var flagListDescription = listCmd.String(flagNameDesc, "", "only show masked emails whose description matches this regular expression")
+var flagListCreatedBy = listCmd.String(flagNameCreatedBy, "", "only show masked emails created by this app")
+var flagListCreatedAfter = listCmd.String(flagNameCreatedAfter, "", "only show masked emails created after this date (RFC3339, YYYY-MM-DD or age like 72h, 30d)")
+var flagListCreatedBefore = listCmd.String(flagNameCreatedBefore, "", "only show masked emails created before this date")
+var flagListMessageAfter = listCmd.String(flagNameMessageAfter, "", "only show masked emails that last received a message after this date")
+var flagListMessageBefore = listCmd.String(flagNameMessageBefore, "", "only show masked emails that last received a message before this date")
+var flagListSort = listCmd.String(flagNameSort, "", "sort by this field, prefix with - for descending order (eg. -createdAt)")
+var flagListLimit = listCmd.Int(flagNameLimit, 0, "only show the first n masked emails (0 for all)")
 
 // flags for create command
 var createCmd = flag.NewFlagSet(actionTypeCreate, flag.ExitOnError)
 var flagCreateDomain = createCmd.String(flagNameDomain, "", "domain for the masked email (optional)")
 var flagCreateDescription = createCmd.String(flagNameDesc, "", "description for the masked email (optional)")
 var flagCreateEmailPrefix = createCmd.String(flagNamePrefix, "", "prefix for the masked email (optional)")
+var flagCreateURL = createCmd.String(flagNameURL, "", "url for the masked email, eg. the login page of the site (optional)")
 var flagCreateEnabled = createCmd.Bool(flagNameEnabled, true, "is masked email enabled (true|false)")
+var flagCreateReuse = createCmd.Bool(flagNameReuse, false, "return the existing enabled masked email for the domain instead of creating a new one")
+var flagCreateReenable = createCmd.Bool(flagNameReenable, false, "with -"+flagNameReuse+", enable an existing disabled or pending masked email for the domain")
 
 // flags for update command
 var updateCmd = flag.NewFlagSet(actionTypeUpdate, flag.ExitOnError)
 var flagUpdateDomain = updateCmd.String(flagNameDomain, "", "domain for the masked email (optional, only updated if argument passed)")
 var flagUpdateDescription = updateCmd.String(flagNameDesc, "", "description for the masked email (optional, only updated if argument passed)")
+var flagUpdateURL = updateCmd.String(flagNameURL, "", "url for the masked email, empty to clear it (optional, only updated if argument passed)")
+
+// flags for enable, disable and delete commands
+var enableCmd = flag.NewFlagSet(actionTypeEnable, flag.ExitOnError)
+var flagEnableStdin = enableCmd.Bool(flagNameStdin, false, "read additional masked emails from stdin, one per line")
+var disableCmd = flag.NewFlagSet(actionTypeDisable, flag.ExitOnError)
+var flagDisableStdin = disableCmd.Bool(flagNameStdin, false, "read additional masked emails from stdin, one per line")
+var deleteCmd = flag.NewFlagSet(actionTypeDelete, flag.ExitOnError)
+var flagDeleteStdin = deleteCmd.Bool(flagNameStdin, false, "read additional masked emails from stdin, one per line")
+
+// flags for pending command
+var pendingCmd = flag.NewFlagSet(actionTypePending, flag.ExitOnError)
+var flagPendingOlderThan = pendingCmd.Duration(flagNameOlderThan, 0, "only show pending masked emails created at least this long ago, eg. 20h")
+var flagPendingConfirm = pendingCmd.Bool(flagNameConfirm, false, "confirm all shown pending masked emails")
+
+// flags for login command
+var loginCmd = flag.NewFlagSet(actionTypeLogin, flag.ExitOnError)
+var flagLoginStore = loginCmd.String(flagNameStore, "", "where to store the token ("+strings.Join(tokenStores, "|")+") (default: keyring if available, otherwise file)")
+var flagLoginOAuth = loginCmd.Bool(flagNameOAuth, false, "log in with OAuth in the browser instead of an API token")
+var flagLoginDevice = loginCmd.Bool(flagNameDevice, false, "log in with the OAuth device flow, for machines without a browser (implies -"+flagNameOAuth+")")
+var flagLoginOAuthIssuer = loginCmd.String(flagNameOAuthIssuer, pkg.DefaultOAuthIssuer, "the OAuth authorization server")
+var flagLoginClientID = loginCmd.String(flagNameClientID, "", "the registered OAuth client id (default: the profile's oauth_client_id)")
+
+// flags for serve command
+var serveCmd = flag.NewFlagSet(actionTypeServe, flag.ExitOnError)
+var flagServeListen = serveCmd.String(flagNameListen, "127.0.0.1:8080", "the address to listen on")
+var flagServeKeys = serveCmd.String(flagNameKeys, "", "yaml file with the api clients and their keys (required)")
+var flagServeRate = serveCmd.Int(flagNameRate, 60, "requests per minute allowed for each api client (0 to disable)")
+var flagServeAuditLog = serveCmd.String(flagNameAuditLog, "", "append the audit log to this file (default: stderr)")
+
+// flags for export and import commands
+var exportCmd = flag.NewFlagSet(actionTypeExport, flag.ExitOnError)
+var flagExportFile = exportCmd.String(flagNameFile, "", "write the export to this file instead of stdout")
+var importCmd = flag.NewFlagSet(actionTypeImport, flag.ExitOnError)
+var flagImportDryRun = importCmd.Bool(flagNameDryRun, false, "only show the changes, don't apply them")
+var flagImportFields = importCmd.String(flagNameFields, strings.Join(importFields, ","), "comma separated fields to update")
+
+// flags for mock-server command
+var mockCmd = flag.NewFlagSet(actionTypeMock, flag.ExitOnError)
+var flagMockListen = mockCmd.String(flagNameListen, "127.0.0.1:8025", "the address to listen on")
+var flagMockSeed = mockCmd.String(flagNameSeed, "", "json file with masked emails to start with, as written by "+actionTypeExport)
+var flagMockLatency = mockCmd.Duration(flagNameLatency, 0, "delay every response by this duration")
+var flagMockFailRate = mockCmd.Float64(flagNameFailRate, 0, "fraction of api requests to fail with 503 (0 to 1)")
 
 var args []string
+var out *printer
+var cfg *config
+var cfgPath string
+var activeProfileName string
+var activeProfile *profile
 var action actionType = actionTypeUnknown
 var commandArg string
 var envToken string
+var tokenFromProfile bool
+var clientAuth pkg.Authenticator
 
 func isFlagPassed(set flag.FlagSet, name string) bool {
 	found := false
@@ -90,7 +235,237 @@ func isFlagPassed(set flag.FlagSet, name string) bool {
 	return found
 }
 
-func init() {
+// userAgent identifies the CLI build and the configured appname to the API.
+func userAgent() string {
+	return fmt.Sprintf("%s/%s (%s)", defaultAppname, buildVersion, *flagAppname)
+}
+
+// exitCode maps an error returned by the client to the process exit code.
+func exitCode(err error) int {
+	var httpErr *pkg.HTTPError
+	var requestErr *pkg.RequestError
+	var methodErr *pkg.MethodError
+	var setErr *pkg.SetError
+	var oauthErr *pkg.OAuthError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitCodeCanceled
+	case errors.As(err, &httpErr) && (httpErr.StatusCode == 401 || httpErr.StatusCode == 403):
+		return exitCodeAuth
+	case errors.As(err, &oauthErr):
+		return exitCodeAuth
+	case errors.As(err, &requestErr), errors.As(err, &methodErr), errors.As(err, &setErr):
+		return exitCodeJMAP
+	case errors.As(err, &httpErr):
+		return exitCodeHTTP
+	default:
+		return exitCodeError
+	}
+}
+
+// fatal logs the error with some context and exits with a code matching the
+// kind of error.
+func fatal(err error, msg string) {
+	code := exitCode(err)
+
+	switch code {
+	case exitCodeAuth:
+		log.Printf("%s: %v (check your API token and its scopes)", msg, err)
+	case exitCodeCanceled:
+		log.Printf("%s: canceled", msg)
+	default:
+		log.Printf("%s: %v", msg, err)
+	}
+
+	os.Exit(code)
+}
+
+// readTargets returns the masked emails or IDs passed as arguments to a
+// command, plus one per line from stdin if requested. Blank lines and lines
+// starting with "#" are ignored.
+func readTargets(set *flag.FlagSet, fromStdin bool) ([]string, error) {
+	var targets []string
+	for _, arg := range set.Args() {
+		if arg = strings.TrimSpace(arg); arg != "" {
+			targets = append(targets, arg)
+		}
+	}
+
+	if fromStdin {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			targets = append(targets, line)
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return targets, nil
+}
+
+// changeState sets the state of all masked emails passed to the command in a
+// single request.
+func changeState(
+	ctx context.Context,
+	client *pkg.Client,
+	set *flag.FlagSet,
+	fromStdin *bool,
+	state pkg.MaskedEmailState,
+	done string,
+	doing string,
+) {
+	// parse command-specific args
+	set.Parse(args[1:])
+
+	targets, err := readTargets(set, *fromStdin)
+	if err != nil {
+		fatal(err, "reading stdin")
+	}
+
+	if len(targets) == 0 {
+		log.Printf("Usage: %s [-%s] <maskedemail|id>...", set.Name(), flagNameStdin)
+		os.Exit(1)
+	}
+
+	session, err := client.SessionContext(ctx)
+	if err != nil {
+		fatal(err, "initializing session")
+	}
+
+	ids, err := lookupMaskedEmailIDs(ctx, client, session, targets)
+	if err != nil {
+		fatal(err, fmt.Sprintf("error %s masked email", doing))
+	}
+
+	updates := make(map[string][]pkg.UpdateOption, len(ids))
+	for _, id := range ids {
+		updates[id] = []pkg.UpdateOption{pkg.WithUpdateState(state)}
+	}
+
+	res, err := client.UpdateMaskedEmailsContext(ctx, session, *flagAccountID, updates)
+	if _, ok := err.(pkg.SetErrors); err != nil && !ok {
+		fatal(err, fmt.Sprintf("error %s masked email", doing))
+	}
+
+	// report each masked email separately, failures go to stderr
+	var failed error
+	var updatedIDs []string
+	for i, target := range targets {
+		if setErr, ok := res.NotUpdated[ids[i]]; ok {
+			log.Printf("error %s masked email %s: %v", doing, target, &setErr)
+			failed = &setErr
+			continue
+		}
+
+		if _, ok := res.Updated[ids[i]]; !ok {
+			log.Printf("error %s masked email %s: not updated", doing, target)
+			failed = fmt.Errorf("%s not updated", target)
+			continue
+		}
+
+		// success output
+		if out.isText() {
+			fmt.Printf("%s masked email: %s\n", done, target)
+		}
+		updatedIDs = append(updatedIDs, ids[i])
+	}
+
+	if !out.isText() {
+		updated, err := client.GetMaskedEmailsContext(ctx, session, *flagAccountID, updatedIDs)
+		if err != nil {
+			fatal(err, "error fetching updated masked emails")
+		}
+
+		if err := out.print(maskedEmailRecords(updated), maskedEmailColumns(out, true), nil); err != nil {
+			fatal(err, "printing masked emails")
+		}
+	}
+
+	if failed != nil {
+		os.Exit(exitCode(failed))
+	}
+}
+
+// parseDate parses a date flag value, either as absolute RFC3339 timestamp or
+// YYYY-MM-DD date, or as age relative to now such as "72h" or "30d".
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		if n, err := strconv.Atoi(days); err == nil {
+			return time.Now().AddDate(0, 0, -n), nil
+		}
+	}
+
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// listFilter builds the masked email filter from the list command flags.
+func listFilter() (pkg.MaskedEmailFilter, error) {
+	filter := pkg.MaskedEmailFilter{
+		Domain:    strings.TrimSpace(*flagListDomain),
+		CreatedBy: strings.TrimSpace(*flagListCreatedBy),
+	}
+
+	for _, state := range strings.Split(*flagListState, ",") {
+		if state = strings.TrimSpace(state); state != "" {
+			filter.States = append(filter.States, pkg.MaskedEmailState(strings.ToLower(state)))
+		}
+	}
+
+	if *flagListDescription != "" {
+		re, err := regexp.Compile(*flagListDescription)
+		if err != nil {
+			return filter, fmt.Errorf("invalid -%s: %w", flagNameDesc, err)
+		}
+		filter.Description = re
+	}
+
+	dates := []struct {
+		name  string
+		value string
+		dest  *time.Time
+	}{
+		{flagNameCreatedAfter, *flagListCreatedAfter, &filter.CreatedAfter},
+		{flagNameCreatedBefore, *flagListCreatedBefore, &filter.CreatedBefore},
+		{flagNameMessageAfter, *flagListMessageAfter, &filter.LastMessageAfter},
+		{flagNameMessageBefore, *flagListMessageBefore, &filter.LastMessageBefore},
+	}
+	for _, date := range dates {
+		t, err := parseDate(date.value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid -%s: %w", date.name, err)
+		}
+		*date.dest = t
+	}
+
+	return filter, nil
+}
+
+// setup parses the flags and resolves the global settings. It runs from main
+// rather than init so the package can be tested.
+func setup() {
 	flag.Parse()
 
 	// get all args after the global args
@@ -105,33 +480,83 @@ func init() {
 		fmt.Println("Commands:")
 
 		// create
-		fmt.Printf("  %s %s [-%s \"<domain>\"] [-%s \"<description>\"] [-%s \"<prefix>\"] [-%s=true|false (default true)]\n",
-			defaultAppname, actionTypeCreate, flagNameDomain, flagNameDesc, flagNamePrefix, flagNameEnabled)
+		fmt.Printf("  %s %s [-%s \"<domain>\"] [-%s \"<description>\"] [-%s \"<prefix>\"] [-%s \"<url>\"] [-%s=true|false (default true)] [-%s [-%s]]\n",
+			defaultAppname, actionTypeCreate, flagNameDomain, flagNameDesc, flagNamePrefix, flagNameURL, flagNameEnabled, flagNameReuse, flagNameReenable)
 
 		// list
-		fmt.Printf("  %s %s [-%s] [-%s]\n",
-			defaultAppname, actionTypeList, flagNameShowDeleted, flagNameShowAllFields)
+		fmt.Printf("  %s %s [-%s] [-%s] [-%s <states>] [-%s <domain>] [-%s <regexp>] [-%s <app>] [-%s|-%s <date>] [-%s|-%s <date>] [-%s [-]<field>] [-%s <n>]\n",
+			defaultAppname, actionTypeList, flagNameShowDeleted, flagNameShowAllFields, flagNameState, flagNameDomain, flagNameDesc,
+			flagNameCreatedBy, flagNameCreatedAfter, flagNameCreatedBefore, flagNameMessageAfter, flagNameMessageBefore, flagNameSort, flagNameLimit)
 
 		// enable
-		fmt.Printf("  %s %s <maskedemail>\n",
-			defaultAppname, actionTypeEnable)
+		fmt.Printf("  %s %s [-%s] <maskedemail|id>...\n",
+			defaultAppname, actionTypeEnable, flagNameStdin)
 
 		// disable
-		fmt.Printf("  %s %s <maskedemail>\n",
-			defaultAppname, actionTypeDisable)
+		fmt.Printf("  %s %s [-%s] <maskedemail|id>...\n",
+			defaultAppname, actionTypeDisable, flagNameStdin)
 
 		// delete
-		fmt.Printf("  %s %s <maskedemail>\n",
-			defaultAppname, actionTypeDelete)
+		fmt.Printf("  %s %s [-%s] <maskedemail|id>...\n",
+			defaultAppname, actionTypeDelete, flagNameStdin)
+
+		// confirm
+		fmt.Printf("  %s %s <maskedemail|id>\n",
+			defaultAppname, actionTypeConfirm)
+
+		// pending
+		fmt.Printf("  %s %s [-%s <duration>] [-%s]\n",
+			defaultAppname, actionTypePending, flagNameOlderThan, flagNameConfirm)
 
 		// update
-		fmt.Printf("  %s %s <maskedemail> [-%s \"<domain>\"] [-%s \"<description>\"]\n",
-			defaultAppname, actionTypeUpdate, flagNameDomain, flagNameDesc)
+		fmt.Printf("  %s %s <maskedemail|id> [-%s \"<domain>\"] [-%s \"<description>\"] [-%s \"<url>\"]\n",
+			defaultAppname, actionTypeUpdate, flagNameDomain, flagNameDesc, flagNameURL)
 
 		// session
 		fmt.Printf("  %s %s\n",
 			defaultAppname, actionTypeSession)
 
+		// sync
+		fmt.Printf("  %s %s\n",
+			defaultAppname, actionTypeSync)
+
+		// tui
+		fmt.Printf("  %s %s\n",
+			defaultAppname, actionTypeTUI)
+
+		// export
+		fmt.Printf("  %s [-%s json|jsonl|csv|tsv] %s [-%s <file>]\n",
+			defaultAppname, flagNameOutput, actionTypeExport, flagNameFile)
+
+		// import
+		fmt.Printf("  %s %s [-%s] [-%s <fields>] <file|->\n",
+			defaultAppname, actionTypeImport, flagNameDryRun, flagNameFields)
+
+		// plan, apply
+		fmt.Printf("  %s %s|%s <file>\n",
+			defaultAppname, actionTypePlan, actionTypeApply)
+
+		// serve
+		fmt.Printf("  %s %s -%s <file> [-%s <addr>] [-%s <n>] [-%s <file>]\n",
+			defaultAppname, actionTypeServe, flagNameKeys, flagNameListen, flagNameRate, flagNameAuditLog)
+
+		// mock-server
+		fmt.Printf("  %s %s [-%s <addr>] [-%s <file>] [-%s <duration>] [-%s <rate>]\n",
+			defaultAppname, actionTypeMock, flagNameListen, flagNameSeed, flagNameLatency, flagNameFailRate)
+
+		// login
+		fmt.Printf("  %s %s [-%s %s] [-%s [-%s] [-%s <url>] [-%s <id>]]\n",
+			defaultAppname, actionTypeLogin, flagNameStore, strings.Join(tokenStores, "|"),
+			flagNameOAuth, flagNameDevice, flagNameOAuthIssuer, flagNameClientID)
+
+		// logout
+		fmt.Printf("  %s %s\n",
+			defaultAppname, actionTypeLogout)
+
+		// config
+		fmt.Printf("  %s %s path|show|profiles|use <profile>|get <key>|set <key> <value>|unset <key>\n",
+			defaultAppname, actionTypeConfig)
+
 		// version
 		fmt.Printf("  %s %s\n",
 			defaultAppname, actionTypeVersion)
@@ -143,11 +568,58 @@ func init() {
 		commandArg = strings.ToLower(args[0])
 	}
 
+	var err error
+	cfgPath, err = configPath(*flagConfig)
+	if err != nil {
+		log.Fatalf("locating config file: %v", err)
+	}
+
+	cfg, err = loadConfig(cfgPath)
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+
+	// a profile that was asked for by name has to exist
+	activeProfileName = cfg.profileName(*flagProfile)
+	explicitProfile := *flagProfile != "" || os.Getenv(envProfileVarName) != "" || cfg.DefaultProfile != ""
+	activeProfile, err = cfg.profile(activeProfileName, explicitProfile && commandArg != actionTypeConfig)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	// Check global arguments:
 
-	// CLI parameter have precedence over ENV variables
-	if *flagToken == "" && commandArg != actionTypeVersion {
+	// CLI parameter have precedence over ENV variables, which have precedence
+	// over the config profile
+	if *flagToken == "" {
+		*flagToken, err = explicitToken()
+		if err != nil {
+			log.Fatalf("reading token: %v", err)
+		}
+	}
+
+	needsToken := commandArg != actionTypeVersion &&
+		commandArg != actionTypeConfig &&
+		commandArg != actionTypeLogin &&
+		commandArg != actionTypeLogout &&
+		commandArg != actionTypeMock
+
+	if *flagOffline && needsToken {
+		if commandArg != actionTypeList {
+			log.Fatalf("-%s is only supported by the %s command", flagNameOffline, actionTypeList)
+		}
+		needsToken = false
+	}
+	if *flagToken == "" && needsToken {
 		envToken = os.Getenv(envTokenVarName)
+		if envToken == "" {
+			envToken, err = activeProfile.token(activeProfileName)
+			if err != nil {
+				log.Fatalf("reading token of profile %q: %v", activeProfileName, err)
+			}
+			tokenFromProfile = true
+		}
+
 		if envToken != "" {
 			*flagToken = envToken
 		} else {
@@ -156,10 +628,37 @@ func init() {
 		}
 	}
 
+	clientAuth = pkg.BearerToken(*flagToken)
+	if tokenFromProfile && activeProfile.OAuthClientID != "" {
+		clientAuth, err = profileOAuthAuthenticator(activeProfile, activeProfileName, *flagToken)
+		if err != nil {
+			log.Fatalf("reading oauth token of profile %q: %v", activeProfileName, err)
+		}
+	}
+
+	if *flagAppname == "" {
+		*flagAppname = activeProfile.AppName
+	}
 	if *flagAppname == "" {
 		*flagAppname = defaultAppname
 	}
 
+	if *flagAccountID == "" {
+		*flagAccountID = activeProfile.AccountID
+	}
+
+	if *flagEndpoint == "" {
+		*flagEndpoint = activeProfile.Endpoint
+	}
+
+	out, err = newPrinter(os.Stdout, *flagOutput, *flagFormat)
+	if err != nil {
+		log.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
+	out.utc = *flagUTC
+
 	switch commandArg {
 
 	case actionTypeVersion:
@@ -185,12 +684,69 @@ func init() {
 
 	case actionTypeUpdate:
 		action = actionTypeUpdate
+
+	case actionTypeConfirm:
+		action = actionTypeConfirm
+
+	case actionTypePending:
+		action = actionTypePending
+
+	case actionTypeConfig:
+		action = actionTypeConfig
+
+	case actionTypeLogin:
+		action = actionTypeLogin
+
+	case actionTypeLogout:
+		action = actionTypeLogout
+
+	case actionTypeSync:
+		action = actionTypeSync
+
+	case actionTypeTUI:
+		action = actionTypeTUI
+
+	case actionTypeServe:
+		action = actionTypeServe
+
+	case actionTypeExport:
+		action = actionTypeExport
+
+	case actionTypeImport:
+		action = actionTypeImport
+
+	case actionTypePlan:
+		action = actionTypePlan
+
+	case actionTypeApply:
+		action = actionTypeApply
+
+	case actionTypeMock:
+		action = actionTypeMock
 	}
 }
 
+// newClient creates an API client with the global settings.
+func newClient(auth pkg.Authenticator) *pkg.Client {
+	return pkg.NewClient(
+		"",
+		*flagAppname,
+		"35c941ae",
+		pkg.WithAuthenticator(auth),
+		pkg.WithSessionEndpoint(*flagEndpoint),
+		pkg.WithTimeout(*flagTimeout),
+		pkg.WithUserAgent(userAgent()),
+	)
+}
+
 func main() {
+	setup()
 
-	client := pkg.NewClient(*flagToken, *flagAppname, "35c941ae")
+	// cancel in-flight requests on ctrl-c / termination
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := newClient(clientAuth)
 
 	switch action {
 
@@ -199,9 +755,9 @@ func main() {
 		fmt.Printf("commit: %s\n", buildCommit)
 
 	case actionTypeSession:
-		session, err := client.Session()
+		session, err := client.SessionContext(ctx)
 		if err != nil {
-			log.Fatalf("fetching session: %v", err)
+			fatal(err, "fetching session")
 		}
 		var accIDs []string
 		for accID := range session.Accounts {
@@ -221,180 +777,435 @@ func main() {
 				return accIDs[i] < accIDs[j]
 			},
 		)
+		var accounts []interface{}
 		for _, accID := range accIDs {
-			isPrimary := primaryAccountID == accID
-			isEnabled := session.AccountHasCapability(accID, pkg.MaskedEmailCapabilityURI)
+			accounts = append(accounts, &sessionAccount{
+				ID:                 accID,
+				Name:               session.Accounts[accID].Name,
+				Primary:            primaryAccountID == accID,
+				MaskedEmailEnabled: session.AccountHasCapability(accID, pkg.MaskedEmailCapabilityURI),
+			})
+		}
 
-			fmt.Printf(
-				"%s [%s] (primary: %t, enabled: %t)\n",
-				session.Accounts[accID].Name,
-				accID,
-				isPrimary,
-				isEnabled,
-			)
+		err = out.print(accounts, sessionAccountColumns, func(w io.Writer) {
+			for _, record := range accounts {
+				acc := record.(*sessionAccount)
+				fmt.Fprintf(
+					w,
+					"%s [%s] (primary: %t, enabled: %t)\n",
+					acc.Name,
+					acc.ID,
+					acc.Primary,
+					acc.MaskedEmailEnabled,
+				)
+			}
+		})
+		if err != nil {
+			fatal(err, "printing session")
 		}
 
 	case actionTypeCreate:
 		// parse command-specific args
 		createCmd.Parse(args[1:])
 
+		if *flagCreateReenable && !*flagCreateReuse {
+			log.Fatalf("-%s requires -%s", flagNameReenable, flagNameReuse)
+		}
+
 		domain := strings.TrimSpace(*flagCreateDomain)
 		description := strings.TrimSpace(*flagCreateDescription)
 		emailPrefix := strings.TrimSpace(*flagCreateEmailPrefix)
+		url := strings.TrimSpace(*flagCreateURL)
+
+		// fall back to the defaults of the config profile
+		var err error
+		if !isFlagPassed(*createCmd, flagNameDomain) && activeProfile.Domain != "" {
+			domain, err = renderTemplate(flagNameDomain, activeProfile.Domain, newCreateTemplateData(activeProfileName, "", emailPrefix))
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
+		if !isFlagPassed(*createCmd, flagNameDesc) && activeProfile.Description != "" {
+			description, err = renderTemplate(flagNameDesc, activeProfile.Description, newCreateTemplateData(activeProfileName, domain, emailPrefix))
+			if err != nil {
+				log.Fatalln(err)
+			}
+		}
 
-		session, err := client.Session()
+		session, err := client.SessionContext(ctx)
 		if err != nil {
-			log.Fatalf("initializing session: %v", err)
+			fatal(err, "initializing session")
+		}
+
+		spec := pkg.CreateSpec{
+			Domain:      domain,
+			Description: description,
+			EmailPrefix: emailPrefix,
+			URL:         url,
+			Enabled:     *flagCreateEnabled,
 		}
 
-		createRes, err := client.CreateMaskedEmail(session, *flagAccountID, domain, description, emailPrefix, *flagCreateEnabled)
+		var createRes *pkg.MaskedEmail
+		if *flagCreateReuse {
+			createRes, _, err = client.GetOrCreateMaskedEmailContext(ctx, session, *flagAccountID, spec, *flagCreateReenable)
+		} else {
+			createRes, err = client.CreateMaskedEmailFromSpecContext(ctx, session, *flagAccountID, spec)
+		}
 		if err != nil {
-			log.Fatalf("error creating masked email: %v", err)
+			fatal(err, "error creating masked email")
 		}
 
 		// success output
-		fmt.Println(createRes.Email)
+		err = out.printOne(maskedEmailRecord(createRes), maskedEmailColumns(out, true), func(w io.Writer) {
+			fmt.Fprintln(w, createRes.Email)
+		})
+		if err != nil {
+			fatal(err, "printing masked email")
+		}
 
 	case actionTypeDisable:
-		maskedemail := strings.TrimSpace(args[1])
+		changeState(ctx, client, disableCmd, flagDisableStdin, pkg.MaskedEmailStateDisabled, "disabled", "disabling")
 
-		if maskedemail == "" {
-			log.Fatalln("Usage: disable <maskedemail>")
-		}
+	case actionTypeEnable:
+		changeState(ctx, client, enableCmd, flagEnableStdin, pkg.MaskedEmailStateEnabled, "enabled", "enabling")
+
+	case actionTypeDelete:
+		changeState(ctx, client, deleteCmd, flagDeleteStdin, pkg.MaskedEmailStateDeleted, "deleted", "deleting")
+
+	case actionTypeList:
+		// parse command-specific args
+		listCmd.Parse(args[1:])
 
-		session, err := client.Session()
+		filter, err := listFilter()
 		if err != nil {
-			log.Fatalf("initializing session: %v", err)
+			log.Println(err)
+			listCmd.Usage()
+			os.Exit(1)
+		}
+
+		// asking for deleted masked emails explicitly implies showing them
+		includeDeleted := *flagShowDeleted
+		for _, state := range filter.States {
+			includeDeleted = includeDeleted || state == pkg.MaskedEmailStateDeleted
 		}
 
-		_, err = client.DisableMaskedEmail(session, *flagAccountID, maskedemail)
+		maskedEmails, err := listMaskedEmails(ctx, client, includeDeleted)
 		if err != nil {
-			log.Fatalf("error disabling masked email: %v", err)
+			fatal(err, "err while getting maskedemails")
 		}
 
-		// success output
-		fmt.Printf("disabled masked email: %s\n", maskedemail)
+		maskedEmails = pkg.FilterMaskedEmails(maskedEmails, filter)
 
-	case actionTypeEnable:
+		if *flagListSort != "" {
+			field, err := pkg.ParseSortField(strings.TrimPrefix(*flagListSort, "-"))
+			if err != nil {
+				log.Println(err)
+				listCmd.Usage()
+				os.Exit(1)
+			}
+			pkg.SortMaskedEmails(maskedEmails, field, strings.HasPrefix(*flagListSort, "-"))
+		}
+
+		if *flagListLimit > 0 && len(maskedEmails) > *flagListLimit {
+			maskedEmails = maskedEmails[:*flagListLimit]
+		}
+
+		err = out.print(maskedEmailRecords(maskedEmails), maskedEmailColumns(out, *flagShowAllFields), nil)
+		if err != nil {
+			fatal(err, "printing masked emails")
+		}
+
+	case actionTypeUpdate:
 		maskedemail := strings.TrimSpace(args[1])
 
-		if maskedemail == "" {
-			log.Fatalln("Usage: enable <maskedemail>")
+		// parse command-specific args
+		updateCmd.Parse(args[2:])
+
+		domain := strings.TrimSpace(*flagUpdateDomain)
+		description := strings.TrimSpace(*flagUpdateDescription)
+		url := strings.TrimSpace(*flagUpdateURL)
+
+		session, err := client.SessionContext(ctx)
+		if err != nil {
+			fatal(err, "initializing session")
+		}
+
+		opts := []pkg.UpdateOption{}
+		if isFlagPassed(*updateCmd, flagNameDomain) {
+			opts = append(opts, pkg.WithUpdateDomain(domain))
+		}
+
+		if isFlagPassed(*updateCmd, flagNameDesc) {
+			opts = append(opts, pkg.WithUpdateDescription(description))
+		}
+
+		if isFlagPassed(*updateCmd, flagNameURL) {
+			opts = append(opts, pkg.WithUpdateURL(url))
+		}
+
+		if len(opts) == 0 {
+			log.Println("no update options specified")
+			updateCmd.Usage()
+			os.Exit(1)
+		}
+
+		emailIDs, err := lookupMaskedEmailIDs(ctx, client, session, []string{maskedemail})
+		if err != nil {
+			fatal(err, "error updating masked email")
 		}
+		emailID := emailIDs[0]
 
-		session, err := client.Session()
+		_, err = client.UpdateMaskedEmailContext(ctx, session, *flagAccountID, emailID, opts...)
 		if err != nil {
-			log.Fatalf("initializing session: %v", err)
+			fatal(err, "error updating masked email")
+		}
+
+		if out.isText() {
+			fmt.Printf("updated %s\n", maskedemail)
+			break
 		}
 
-		_, err = client.EnableMaskedEmail(session, *flagAccountID, maskedemail)
+		updated, err := client.GetMaskedEmailContext(ctx, session, *flagAccountID, emailID)
 		if err != nil {
-			log.Fatalf("error enabling masked email: %v", err)
+			fatal(err, "error fetching updated masked email")
 		}
 
-		// success output
-		fmt.Printf("enabled masked email: %s\n", maskedemail)
+		err = out.printOne(maskedEmailRecord(updated), maskedEmailColumns(out, true), nil)
+		if err != nil {
+			fatal(err, "printing masked email")
+		}
 
-	case actionTypeDelete:
+	case actionTypeConfirm:
+		if len(args) < 2 || strings.TrimSpace(args[1]) == "" {
+			log.Fatalf("Usage: %s <maskedemail|id>", actionTypeConfirm)
+		}
 		maskedemail := strings.TrimSpace(args[1])
 
-		if maskedemail == "" {
-			log.Fatalln("Usage: delete <maskedemail>")
+		session, err := client.SessionContext(ctx)
+		if err != nil {
+			fatal(err, "initializing session")
 		}
 
-		session, err := client.Session()
+		_, err = client.ConfirmMaskedEmailContext(ctx, session, *flagAccountID, maskedemail)
 		if err != nil {
-			log.Fatalf("initializing session: %v", err)
+			fatal(err, "error confirming masked email")
+		}
+
+		if out.isText() {
+			fmt.Printf("confirmed masked email: %s\n", maskedemail)
+			break
 		}
 
-		_, err = client.DeleteMaskedEmail(session, *flagAccountID, maskedemail)
+		confirmed, err := client.GetMaskedEmailContext(ctx, session, *flagAccountID, maskedemail)
 		if err != nil {
-			log.Fatalf("error deleting masked email: %v", err)
+			fatal(err, "error fetching confirmed masked email")
 		}
 
-		// success output
-		fmt.Printf("deleted masked email: %s\n", maskedemail)
+		err = out.printOne(maskedEmailRecord(confirmed), maskedEmailColumns(out, true), nil)
+		if err != nil {
+			fatal(err, "printing masked email")
+		}
 
-	case actionTypeList:
+	case actionTypePending:
 		// parse command-specific args
-		listCmd.Parse(args[1:])
+		pendingCmd.Parse(args[1:])
+
+		session, err := client.SessionContext(ctx)
+		if err != nil {
+			fatal(err, "initializing session")
+		}
+
+		maskedEmails, err := client.GetAllMaskedEmailsContext(ctx, session, *flagAccountID, false)
+		if err != nil {
+			fatal(err, "err while getting maskedemails")
+		}
+
+		filter := pkg.MaskedEmailFilter{
+			States: []pkg.MaskedEmailState{pkg.MaskedEmailStatePending},
+		}
+		if *flagPendingOlderThan > 0 {
+			filter.CreatedBefore = time.Now().Add(-*flagPendingOlderThan)
+		}
+
+		// oldest first, as those expire soonest
+		pending := pkg.FilterMaskedEmails(maskedEmails, filter)
+		pkg.SortMaskedEmails(pending, pkg.SortByCreatedAt, false)
+
+		if !*flagPendingConfirm {
+			err = out.print(maskedEmailRecords(pending), maskedEmailColumns(out, true), nil)
+			if err != nil {
+				fatal(err, "printing masked emails")
+			}
+			break
+		}
+
+		updates := make(map[string][]pkg.UpdateOption, len(pending))
+		for _, email := range pending {
+			updates[email.ID] = []pkg.UpdateOption{pkg.WithUpdateState(pkg.MaskedEmailStateEnabled)}
+		}
+
+		var confirmed []*pkg.MaskedEmail
+		var failed error
+		if len(updates) > 0 {
+			res, err := client.UpdateMaskedEmailsContext(ctx, session, *flagAccountID, updates)
+			if _, ok := err.(pkg.SetErrors); err != nil && !ok {
+				fatal(err, "error confirming masked emails")
+			}
+
+			for _, email := range pending {
+				if setErr := res.NotUpdatedError(email.ID); setErr != nil {
+					log.Printf("error confirming masked email %s: %v", email.Email, setErr)
+					failed = setErr
+					continue
+				}
+
+				if _, ok := res.Updated[email.ID]; !ok {
+					log.Printf("error confirming masked email %s: not updated", email.Email)
+					failed = fmt.Errorf("%s not updated", email.Email)
+					continue
+				}
 
-		session, err := client.Session()
+				email.State = pkg.MaskedEmailStateEnabled
+				confirmed = append(confirmed, email)
+			}
+		}
+
+		err = out.print(maskedEmailRecords(confirmed), maskedEmailColumns(out, true), func(w io.Writer) {
+			for _, email := range confirmed {
+				fmt.Fprintf(w, "confirmed masked email: %s\n", email.Email)
+			}
+		})
 		if err != nil {
-			log.Fatalf("initializing session: %v", err)
+			fatal(err, "printing masked emails")
 		}
 
-		maskedEmails, err := client.GetAllMaskedEmails(session, *flagAccountID, *flagShowDeleted)
+		if failed != nil {
+			os.Exit(exitCode(failed))
+		}
+
+	case actionTypeSync:
+		session, err := client.SessionContext(ctx)
 		if err != nil {
-			log.Fatalf("err while getting maskedemails: %v", err)
+			fatal(err, "initializing session")
 		}
 
-		w := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', 0)
+		path, err := cachePath(activeProfileName)
+		if err != nil {
+			fatal(err, "locating cache")
+		}
 
-		// display header line
-		if *flagShowAllFields {
-			fmt.Fprintln(w, "Masked Email\tFor Domain\tDescription\tState\tID\tCreated At\tLast Email At")
-		} else {
-			fmt.Fprintln(w, "Masked Email\tFor Domain\tDescription\tState")
-		}
-
-		// display each masked email
-		for _, email := range maskedEmails {
-			// HACK: trim space here is for hack to deal with possible empty strings
-			if *flagShowAllFields {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
-					email.Email,
-					strings.TrimSpace(email.Domain),
-					strings.TrimSpace(email.Description),
-					email.State,
-					email.ID,
-					email.CreatedAt,
-					email.LastMessageAt)
-			} else {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
-					email.Email,
-					strings.TrimSpace(email.Domain),
-					strings.TrimSpace(email.Description),
-					email.State)
+		c, err := loadCache(path)
+		if err != nil {
+			fatal(err, "loading cache")
+		}
+
+		accCache, result, err := syncCache(ctx, client, session, c, path)
+		if err != nil {
+			fatal(err, "error syncing masked emails")
+		}
+
+		record := newSyncSummary(accCache, result)
+		err = out.printOne(record, syncSummaryColumns, func(w io.Writer) {
+			if result.Full {
+				fmt.Fprintf(w, "synced %d masked emails\n", record.Total)
+				return
 			}
+			fmt.Fprintf(w, "synced %d masked emails (%d created, %d updated, %d destroyed)\n",
+				record.Total, result.Created, result.Updated, result.Destroyed)
+		})
+		if err != nil {
+			fatal(err, "printing sync result")
 		}
-		w.Flush()
 
-	case actionTypeUpdate:
-		maskedemail := strings.TrimSpace(args[1])
+	case actionTypeTUI:
+		if err := runTUI(ctx, client); err != nil {
+			fatal(err, "error running tui")
+		}
 
+	case actionTypeExport:
 		// parse command-specific args
-		updateCmd.Parse(args[2:])
+		exportCmd.Parse(args[1:])
 
-		domain := strings.TrimSpace(*flagUpdateDomain)
-		description := strings.TrimSpace(*flagUpdateDescription)
+		if err := runExport(ctx, client, *flagExportFile, *flagOutput); err != nil {
+			fatal(err, "error exporting masked emails")
+		}
 
-		session, err := client.Session()
-		if err != nil {
-			log.Fatalf("initializing session: %v", err)
+	case actionTypeImport:
+		// parse command-specific args
+		importCmd.Parse(args[1:])
+
+		if importCmd.NArg() != 1 {
+			log.Printf("Usage: %s [-%s] [-%s <fields>] <file|->", actionTypeImport, flagNameDryRun, flagNameFields)
+			os.Exit(1)
 		}
 
-		opts := []pkg.UpdateOption{}
-		if isFlagPassed(*updateCmd, flagNameDomain) {
-			opts = append(opts, pkg.WithUpdateDomain(domain))
+		if err := runImport(ctx, client, importCmd.Arg(0), *flagImportFields, *flagImportDryRun); err != nil {
+			fatal(err, "error importing masked emails")
 		}
 
-		if isFlagPassed(*updateCmd, flagNameDesc) {
-			opts = append(opts, pkg.WithUpdateDescription(description))
+	case actionTypePlan:
+		if len(args) != 2 {
+			log.Printf("Usage: %s <file>", actionTypePlan)
+			os.Exit(1)
 		}
 
-		if len(opts) == 0 {
-			log.Println("no update options specified")
-			updateCmd.Usage()
+		if err := runPlan(ctx, client, args[1]); err != nil {
+			fatal(err, "error planning masked emails")
+		}
+
+	case actionTypeApply:
+		if len(args) != 2 {
+			log.Printf("Usage: %s <file>", actionTypeApply)
 			os.Exit(1)
 		}
 
-		_, err = client.UpdateInfo(session, *flagAccountID, maskedemail, opts...)
+		if err := runApply(ctx, client, args[1]); err != nil {
+			fatal(err, "error applying masked emails")
+		}
+
+	case actionTypeMock:
+		// parse command-specific args
+		mockCmd.Parse(args[1:])
+
+		if err := runMockServer(ctx, *flagMockListen, *flagMockSeed, *flagAppname, *flagMockLatency, *flagMockFailRate); err != nil {
+			fatal(err, "error serving mock api")
+		}
+
+	case actionTypeServe:
+		// parse command-specific args
+		serveCmd.Parse(args[1:])
+
+		if err := runServe(ctx, client, *flagServeListen, *flagServeKeys, *flagServeRate, *flagServeAuditLog); err != nil {
+			fatal(err, "error serving api")
+		}
+
+	case actionTypeLogin:
+		// parse command-specific args
+		loginCmd.Parse(args[1:])
+
+		var err error
+		if *flagLoginOAuth || *flagLoginDevice {
+			clientID := *flagLoginClientID
+			if clientID == "" {
+				clientID = activeProfile.OAuthClientID
+			}
+			err = runOAuthLogin(ctx, *flagLoginStore, *flagLoginOAuthIssuer, clientID, *flagLoginDevice)
+		} else {
+			err = runLogin(ctx, *flagToken, *flagLoginStore)
+		}
 		if err != nil {
-			log.Fatalf("error updating masked email: %v", err)
+			fatal(err, "error logging in")
+		}
+
+	case actionTypeLogout:
+		if err := runLogout(); err != nil {
+			fatal(err, "error logging out")
 		}
 
-		fmt.Printf("updated %s\n", maskedemail)
+	case actionTypeConfig:
+		if err := runConfig(cfg, cfgPath, activeProfileName, args[1:]); err != nil {
+			log.Fatalln(err)
+		}
 
 	default:
 		fmt.Println("action not found")
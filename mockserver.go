@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+	"github.com/dvcrn/maskedemail-cli/pkg/jmaptest"
+)
+
+// runMockServer serves a fake JMAP API keeping masked emails in memory, for
+// trying out commands and integrations without a Fastmail account. seedPath
+// optionally names a json file as written by export to start with. Created
+// masked emails are attributed to appName.
+func runMockServer(ctx context.Context, addr string, seedPath string, appName string, latency time.Duration, failureRate float64) error {
+	opts := []jmaptest.Option{
+		jmaptest.WithCreatedBy(appName),
+		jmaptest.WithLatency(latency),
+		jmaptest.WithFailureRate(failureRate),
+	}
+
+	if seedPath != "" {
+		data, err := os.ReadFile(seedPath)
+		if err != nil {
+			return err
+		}
+
+		var seed []*pkg.MaskedEmail
+		if err := json.Unmarshal(data, &seed); err != nil {
+			return fmt.Errorf("parsing %s: %w", seedPath, err)
+		}
+		opts = append(opts, jmaptest.WithMaskedEmails(seed...))
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           jmaptest.NewServer(opts...),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	endpoint := fmt.Sprintf("http://%s%s", listener.Addr(), jmaptest.SessionPath)
+	log.Printf("serving a mock JMAP API, use it with: %s=%s %s=any %s list", envEndpointVarName, endpoint, envTokenVarName, defaultAppname)
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
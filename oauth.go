@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+// oauthHTTPClient is used for requests to the authorization server.
+func oauthHTTPClient() *http.Client {
+	return &http.Client{Timeout: *flagTimeout}
+}
+
+// runOAuthLogin authorizes the CLI with OAuth, in the browser or with the
+// device flow, and stores the token for the active profile. The token is
+// stored as JSON in place of an API token.
+func runOAuthLogin(ctx context.Context, store string, issuer string, clientID string, device bool) error {
+	if clientID == "" {
+		return fmt.Errorf("missing -%s of the registered oauth client", flagNameClientID)
+	}
+
+	config, err := pkg.DiscoverOAuth(ctx, oauthHTTPClient(), issuer, clientID)
+	if err != nil {
+		return fmt.Errorf("discovering oauth endpoints: %w", err)
+	}
+
+	var token *pkg.OAuthToken
+	if device {
+		token, err = authorizeDevice(ctx, config)
+	} else {
+		token, err = authorizeInBrowser(ctx, config)
+	}
+	if err != nil {
+		return err
+	}
+
+	session, err := newClient(pkg.NewOAuthAuthenticator(config, token)).SessionContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	store, err = storeLogin(store, string(data), config)
+	if err != nil {
+		return err
+	}
+
+	printLoggedIn(session, store)
+	return nil
+}
+
+// authorizeInBrowser runs the authorization code flow with PKCE, receiving
+// the code on a loopback redirect.
+func authorizeInBrowser(ctx context.Context, config *pkg.OAuthConfig) (*pkg.OAuthToken, error) {
+	if config.AuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("authorization server has no authorization endpoint, try -%s", flagNameDevice)
+	}
+
+	pkce, err := pkg.NewPKCE()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := pkg.NewOAuthState()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listening for oauth redirect: %w", err)
+	}
+	redirectURI := fmt.Sprintf("http://%s/callback", listener.Addr())
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		if query.Get("state") != state {
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+
+		res := result{code: query.Get("code")}
+		switch {
+		case query.Get("error") != "":
+			res.err = &pkg.OAuthError{Code: query.Get("error"), Description: query.Get("error_description")}
+		case res.code == "":
+			res.err = errors.New("oauth redirect is missing the code")
+		}
+
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusBadRequest)
+		} else {
+			fmt.Fprintf(w, "%s is authorized, you can close this window.\n", defaultAppname)
+		}
+
+		select {
+		case results <- res:
+		default:
+		}
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	fmt.Fprintf(os.Stderr, "Open this URL in your browser to authorize %s:\n\n  %s\n\n", defaultAppname, config.AuthCodeURL(state, redirectURI, pkce))
+
+	var res result
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res = <-results:
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	return config.Exchange(ctx, res.code, redirectURI, pkce)
+}
+
+// authorizeDevice runs the device authorization flow.
+func authorizeDevice(ctx context.Context, config *pkg.OAuthConfig) (*pkg.OAuthToken, error) {
+	auth, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if auth.VerificationURIComplete != "" {
+		fmt.Fprintf(os.Stderr, "Open %s to authorize %s, or open %s and enter the code %s\n",
+			auth.VerificationURIComplete, defaultAppname, auth.VerificationURI, auth.UserCode)
+	} else {
+		fmt.Fprintf(os.Stderr, "Open %s and enter the code %s to authorize %s\n",
+			auth.VerificationURI, auth.UserCode, defaultAppname)
+	}
+
+	return config.PollDeviceToken(ctx, auth)
+}
+
+// profileOAuthAuthenticator creates an authenticator from the OAuth token
+// stored for the profile. Refreshed tokens are written back to the same store.
+func profileOAuthAuthenticator(p *profile, profileName string, stored string) (*pkg.OAuthAuthenticator, error) {
+	var token pkg.OAuthToken
+	if err := json.Unmarshal([]byte(stored), &token); err != nil {
+		return nil, fmt.Errorf("decoding stored token: %w", err)
+	}
+
+	config := &pkg.OAuthConfig{
+		ClientID:      p.OAuthClientID,
+		TokenEndpoint: p.OAuthTokenURL,
+		HTTPClient:    oauthHTTPClient(),
+	}
+
+	auth := pkg.NewOAuthAuthenticator(config, &token)
+	auth.OnRefresh = func(token *pkg.OAuthToken) error {
+		data, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+
+		return persistToken(p, profileName, string(data))
+	}
+
+	return auth, nil
+}
+
+// persistToken updates the token of the profile in its current store. Tokens
+// read from an env variable or command can't be updated and are kept in
+// memory only.
+func persistToken(p *profile, profileName string, token string) error {
+	switch p.tokenStore() {
+	case tokenStoreKeyring:
+		return keyringSet(profileName, token)
+	case tokenStoreFile:
+		return writeTokenFile(p.TokenFile, token)
+	case tokenStoreConfig:
+		p.Token = token
+		return cfg.save(cfgPath)
+	default:
+		return nil
+	}
+}
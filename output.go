@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputCSV   = "csv"
+	outputTSV   = "tsv"
+)
+
+var outputFormats = []string{outputTable, outputJSON, outputJSONL, outputCSV, outputTSV}
+
+// column is a single column of table, csv and tsv output.
+type column struct {
+	// header is shown in table output
+	header string
+	// field is the header for csv/tsv output, matching the json field name
+	field string
+	value func(record interface{}) string
+}
+
+// printer writes command results in the format selected with the global
+// -output and -format flags.
+type printer struct {
+	w      io.Writer
+	output string
+	tmpl   *template.Template
+	// utc shows times in UTC instead of the local timezone in table output
+	utc bool
+	now func() time.Time
+}
+
+func newPrinter(w io.Writer, output string, format string) (*printer, error) {
+	p := &printer{w: w, output: output, now: time.Now}
+
+	valid := false
+	for _, f := range outputFormats {
+		valid = valid || f == output
+	}
+	if !valid {
+		return nil, fmt.Errorf("unknown output format %q (valid: %s)", output, strings.Join(outputFormats, "|"))
+	}
+
+	if format != "" {
+		tmpl, err := template.New("format").Parse(format)
+		if err != nil {
+			return nil, fmt.Errorf("parsing format template: %w", err)
+		}
+		p.tmpl = tmpl
+	}
+
+	return p, nil
+}
+
+// isText returns true if results are printed as human readable text, in
+// which case commands may print free-form messages instead of records.
+func (p *printer) isText() bool {
+	return p.tmpl == nil && p.output == outputTable
+}
+
+// printOne prints the result of a command returning a single record. In json
+// output, the record is printed as an object rather than an array.
+func (p *printer) printOne(record interface{}, columns []column, text func(w io.Writer)) error {
+	if p.tmpl == nil && p.output == outputJSON {
+		return p.writeJSON(record)
+	}
+
+	return p.print([]interface{}{record}, columns, text)
+}
+
+// print prints the records of a command. In table output, text is used if set
+// instead of a table of columns.
+func (p *printer) print(records []interface{}, columns []column, text func(w io.Writer)) error {
+	if p.tmpl != nil {
+		for _, record := range records {
+			if err := p.tmpl.Execute(p.w, record); err != nil {
+				return err
+			}
+			fmt.Fprintln(p.w)
+		}
+
+		return nil
+	}
+
+	switch p.output {
+	case outputJSON:
+		return p.writeJSON(records)
+
+	case outputJSONL:
+		enc := json.NewEncoder(p.w)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case outputCSV, outputTSV:
+		w := csv.NewWriter(p.w)
+		if p.output == outputTSV {
+			w.Comma = '\t'
+		}
+
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = c.field
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			for i, c := range columns {
+				row[i] = c.value(record)
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+
+		w.Flush()
+		return w.Error()
+
+	default:
+		if text != nil {
+			text(p.w)
+			return nil
+		}
+
+		w := tabwriter.NewWriter(p.w, 1, 1, 1, ' ', 0)
+
+		headers := make([]string, len(columns))
+		for i, c := range columns {
+			headers[i] = c.header
+		}
+		fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+		values := make([]string, len(columns))
+		for _, record := range records {
+			for i, c := range columns {
+				values[i] = c.value(record)
+			}
+			fmt.Fprintln(w, strings.Join(values, "\t"))
+		}
+
+		return w.Flush()
+	}
+}
+
+// inZone returns t in the timezone times are shown in.
+func (p *printer) inZone(t time.Time) time.Time {
+	if p.utc {
+		return t.UTC()
+	}
+	return t.Local()
+}
+
+// formatTime formats t for csv/tsv output as RFC 3339 in UTC, and for table
+// output in the local timezone (or UTC with -utc) along with how long ago it
+// was. Zero times are empty.
+func (p *printer) formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	if p.output != outputTable {
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	return p.inZone(t).Format("2006-01-02 15:04") + " (" + relativeTime(t, p.now()) + ")"
+}
+
+// relativeTime describes t relative to now, eg. "3 days ago" or "in 2 hours".
+func relativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	if d < time.Minute {
+		return "just now"
+	}
+
+	var n int
+	var unit string
+	switch {
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	case d < 30*24*time.Hour:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d < 365*24*time.Hour:
+		n, unit = int(d/(30*24*time.Hour)), "month"
+	default:
+		n, unit = int(d/(365*24*time.Hour)), "year"
+	}
+
+	if n != 1 {
+		unit += "s"
+	}
+
+	if future {
+		return fmt.Sprintf("in %d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
+}
+
+func (p *printer) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// maskedEmailRecords prepares masked emails for output.
+func maskedEmailRecords(maskedEmails []*pkg.MaskedEmail) []interface{} {
+	records := make([]interface{}, len(maskedEmails))
+	for i, email := range maskedEmails {
+		records[i] = maskedEmailRecord(email)
+	}
+
+	return records
+}
+
+// maskedEmailRecord prepares a masked email for output.
+func maskedEmailRecord(email *pkg.MaskedEmail) *pkg.MaskedEmail {
+	record := *email
+
+	// HACK: trim space here is for hack to deal with possible empty strings
+	record.Domain = strings.TrimSpace(record.Domain)
+	record.Description = strings.TrimSpace(record.Description)
+
+	return &record
+}
+
+func maskedEmailColumn(header, field string, value func(email *pkg.MaskedEmail) string) column {
+	return column{
+		header: header,
+		field:  field,
+		value: func(record interface{}) string {
+			return value(record.(*pkg.MaskedEmail))
+		},
+	}
+}
+
+var (
+	columnEmail       = maskedEmailColumn("Masked Email", "email", func(e *pkg.MaskedEmail) string { return e.Email })
+	columnDomain      = maskedEmailColumn("For Domain", "forDomain", func(e *pkg.MaskedEmail) string { return e.Domain })
+	columnDescription = maskedEmailColumn("Description", "description", func(e *pkg.MaskedEmail) string { return e.Description })
+	columnState       = maskedEmailColumn("State", "state", func(e *pkg.MaskedEmail) string { return string(e.State) })
+	columnID          = maskedEmailColumn("ID", "id", func(e *pkg.MaskedEmail) string { return e.ID })
+	columnCreatedBy   = maskedEmailColumn("Created By", "createdBy", func(e *pkg.MaskedEmail) string { return e.CreatedBy })
+	columnURL         = maskedEmailColumn("URL", "url", func(e *pkg.MaskedEmail) string { return e.URL })
+)
+
+// maskedEmailTimeColumns returns the createdAt and lastMessageAt columns,
+// which depend on the output format and timezone of the printer.
+func maskedEmailTimeColumns(p *printer) (createdAt, lastMessageAt column) {
+	createdAt = maskedEmailColumn("Created At", "createdAt", func(e *pkg.MaskedEmail) string {
+		return p.formatTime(e.CreatedAt)
+	})
+	lastMessageAt = maskedEmailColumn("Last Email At", "lastMessageAt", func(e *pkg.MaskedEmail) string {
+		if e.LastMessageAt == nil {
+			return ""
+		}
+		return p.formatTime(*e.LastMessageAt)
+	})
+
+	return createdAt, lastMessageAt
+}
+
+// maskedEmailColumns returns the columns to show for masked emails. Machine
+// readable formats always include all fields.
+func maskedEmailColumns(p *printer, allFields bool) []column {
+	if p.output == outputTable && !allFields {
+		return []column{columnEmail, columnDomain, columnDescription, columnState}
+	}
+
+	columnCreatedAt, columnLastMessageAt := maskedEmailTimeColumns(p)
+	if p.output == outputTable {
+		return []column{columnEmail, columnDomain, columnDescription, columnState, columnID, columnCreatedAt, columnLastMessageAt, columnURL}
+	}
+
+	return []column{
+		columnEmail,
+		columnDomain,
+		columnDescription,
+		columnState,
+		columnID,
+		columnCreatedAt,
+		columnLastMessageAt,
+		columnCreatedBy,
+		columnURL,
+	}
+}
+
+// sessionAccount is the output record of the session command.
+type sessionAccount struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Primary            bool   `json:"primary"`
+	MaskedEmailEnabled bool   `json:"maskedEmailEnabled"`
+}
+
+func sessionAccountColumn(header, field string, value func(acc *sessionAccount) string) column {
+	return column{
+		header: header,
+		field:  field,
+		value: func(record interface{}) string {
+			return value(record.(*sessionAccount))
+		},
+	}
+}
+
+var sessionAccountColumns = []column{
+	sessionAccountColumn("Name", "name", func(a *sessionAccount) string { return a.Name }),
+	sessionAccountColumn("ID", "id", func(a *sessionAccount) string { return a.ID }),
+	sessionAccountColumn("Primary", "primary", func(a *sessionAccount) string { return fmt.Sprint(a.Primary) }),
+	sessionAccountColumn("Enabled", "maskedEmailEnabled", func(a *sessionAccount) string { return fmt.Sprint(a.MaskedEmailEnabled) }),
+}
+
+// syncSummary is the output record of the sync command.
+type syncSummary struct {
+	AccountID string `json:"accountId"`
+	State     string `json:"state"`
+	Full      bool   `json:"full"`
+	Created   int    `json:"created"`
+	Updated   int    `json:"updated"`
+	Destroyed int    `json:"destroyed"`
+	Total     int    `json:"total"`
+}
+
+func newSyncSummary(cache *pkg.MaskedEmailCache, result *pkg.CacheSyncResult) *syncSummary {
+	return &syncSummary{
+		AccountID: cache.AccountID,
+		State:     cache.State,
+		Full:      result.Full,
+		Created:   result.Created,
+		Updated:   result.Updated,
+		Destroyed: result.Destroyed,
+		Total:     len(cache.Emails),
+	}
+}
+
+func syncSummaryColumn(header, field string, value func(s *syncSummary) string) column {
+	return column{
+		header: header,
+		field:  field,
+		value: func(record interface{}) string {
+			return value(record.(*syncSummary))
+		},
+	}
+}
+
+var syncSummaryColumns = []column{
+	syncSummaryColumn("Account ID", "accountId", func(s *syncSummary) string { return s.AccountID }),
+	syncSummaryColumn("State", "state", func(s *syncSummary) string { return s.State }),
+	syncSummaryColumn("Full", "full", func(s *syncSummary) string { return fmt.Sprint(s.Full) }),
+	syncSummaryColumn("Created", "created", func(s *syncSummary) string { return fmt.Sprint(s.Created) }),
+	syncSummaryColumn("Updated", "updated", func(s *syncSummary) string { return fmt.Sprint(s.Updated) }),
+	syncSummaryColumn("Destroyed", "destroyed", func(s *syncSummary) string { return fmt.Sprint(s.Destroyed) }),
+	syncSummaryColumn("Total", "total", func(s *syncSummary) string { return fmt.Sprint(s.Total) }),
+}
@@ -2,18 +2,22 @@ package pkg
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-
-	"github.com/mitchellh/mapstructure"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 )
 
 const (
-	// sessionEndpoint is used to auto-discover the main API endpoint
-	sessionEndpoint = "https://api.fastmail.com/jmap/session"
+	// DefaultSessionEndpoint is used to auto-discover the main API endpoint
+	// unless overridden with WithSessionEndpoint.
+	DefaultSessionEndpoint = "https://api.fastmail.com/jmap/session"
 
 	// MaskedEmailCapabilityURI is the capability URI for the Masked Email
 	// feature within the JMAP API.
@@ -42,33 +46,171 @@ type Session interface {
 }
 
 type Client struct {
-	auth     string
-	clientID string
-	appName  string
+	auth            Authenticator
+	clientID        string
+	appName         string
+	sessionEndpoint string
+	userAgent       string
+	httpClient      *http.Client
+	// transport and timeout are applied to httpClient after all options ran,
+	// so they aren't lost if WithHTTPClient comes later
+	transport http.RoundTripper
+	timeout   *time.Duration
+}
+
+// ClientOption configures optional behaviour of a Client.
+type ClientOption func(client *Client)
+
+// WithSessionEndpoint overrides the JMAP session URL used for auto-discovery,
+// eg. to target a staging server, a proxy or a local stand-in.
+func WithSessionEndpoint(endpoint string) ClientOption {
+	return func(client *Client) {
+		if endpoint != "" {
+			client.sessionEndpoint = endpoint
+		}
+	}
 }
 
-func NewClient(token, appName, clientID string) *Client {
-	return &Client{
-		auth:     token,
-		appName:  appName,
-		clientID: clientID,
+// WithHTTPClient sets the HTTP client used for all requests. The client is
+// copied, so WithTransport and WithTimeout, which take precedence regardless
+// of the order of options, don't modify the caller's value.
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(client *Client) {
+		if httpClient != nil {
+			c := *httpClient
+			client.httpClient = &c
+		}
+	}
+}
+
+// WithTransport sets the round tripper used for all requests, eg. to inject
+// proxies, recording transports or mTLS configuration.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(client *Client) {
+		client.transport = transport
 	}
 }
 
-// doRequest adds common headers and executes the HTTP request.
+// WithTimeout limits the time a single request may take, including reading
+// the response body. A zero duration means no timeout.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(client *Client) {
+		client.timeout = &timeout
+	}
+}
+
+// WithAuthenticator replaces the static token passed to NewClient, eg. with
+// an *OAuthAuthenticator.
+func WithAuthenticator(auth Authenticator) ClientOption {
+	return func(client *Client) {
+		if auth != nil {
+			client.auth = auth
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(client *Client) {
+		if userAgent != "" {
+			client.userAgent = userAgent
+		}
+	}
+}
+
+func NewClient(token, appName, clientID string, opts ...ClientOption) *Client {
+	client := &Client{
+		auth:            BearerToken(token),
+		appName:         appName,
+		clientID:        clientID,
+		sessionEndpoint: DefaultSessionEndpoint,
+		userAgent:       appName,
+		httpClient:      &http.Client{},
+	}
+
+	for _, opt := range opts {
+		opt(client)
+	}
+
+	if client.transport != nil {
+		client.httpClient.Transport = client.transport
+	}
+	if client.timeout != nil {
+		client.httpClient.Timeout = *client.timeout
+	}
+
+	return client
+}
+
+// WithAppName returns a copy of the client that identifies as appName when
+// creating masked emails. The copy shares the credentials and HTTP client.
+func (client *Client) WithAppName(appName string) *Client {
+	c := *client
+	c.appName = appName
+
+	return &c
+}
+
+// doRequest adds common headers and executes the HTTP request. If the server
+// rejects the credentials and the authenticator can refresh them, the request
+// is retried once.
 func (client *Client) doRequest(req *http.Request) (*http.Response, error) {
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("authorization", fmt.Sprintf("Bearer %s", client.auth))
-	return http.DefaultClient.Do(req)
+	if client.userAgent != "" {
+		req.Header.Set("User-Agent", client.userAgent)
+	}
+
+	if err := client.auth.Authorize(req); err != nil {
+		return nil, err
+	}
+
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	refresher, ok := client.auth.(Refresher)
+	if res.StatusCode != http.StatusUnauthorized || !ok {
+		return res, nil
+	}
+
+	if req.Body != nil && req.GetBody == nil {
+		// can't replay the body, let the caller handle the 401
+		return res, nil
+	}
+
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := refresher.Refresh(req); err != nil {
+		// keep the 401 matchable, eg. for exit codes
+		return nil, fmt.Errorf("%w (refreshing credentials: %v)", newHTTPError(res.StatusCode, res.Status, body), err)
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := client.auth.Authorize(retry); err != nil {
+		return nil, err
+	}
+
+	return client.httpClient.Do(retry)
 }
 
-func (client *Client) sendRequest(session Session, r *APIRequest) (*APIResponse, error) {
+func (client *Client) sendRequest(ctx context.Context, session Session, r *APIRequest) (*APIResponse, error) {
 	reqJson, err := json.Marshal(r)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", session.ApiEndpoint(), bytes.NewReader(reqJson))
+	req, err := http.NewRequestWithContext(ctx, "POST", session.ApiEndpoint(), bytes.NewReader(reqJson))
 	if err != nil {
 		return nil, err
 	}
@@ -85,6 +227,10 @@ func (client *Client) sendRequest(session Session, r *APIRequest) (*APIResponse,
 		return nil, err
 	}
 
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, newHTTPError(res.StatusCode, res.Status, buf.Bytes())
+	}
+
 	var apiRes APIResponse
 	err = json.Unmarshal(buf.Bytes(), &apiRes)
 	if err != nil {
@@ -97,7 +243,12 @@ func (client *Client) sendRequest(session Session, r *APIRequest) (*APIResponse,
 // Session queries the JMAP auto-discovery endpoint for details about the
 // server and available accounts.
 func (client *Client) Session() (*SessionResource, error) {
-	req, err := http.NewRequest(http.MethodGet, sessionEndpoint, nil)
+	return client.SessionContext(context.Background())
+}
+
+// SessionContext is like Session but uses the given context for the request.
+func (client *Client) SessionContext(ctx context.Context) (*SessionResource, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.sessionEndpoint, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -113,14 +264,58 @@ func (client *Client) Session() (*SessionResource, error) {
 		return nil, err
 	}
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newHTTPError(resp.StatusCode, resp.Status, jsonBody)
+	}
+
 	var session SessionResource
 	if err := json.Unmarshal(jsonBody, &session); err != nil {
 		return nil, err
 	}
 
+	apiURL, err := url.Parse(session.ApiUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid apiUrl in session: %w", err)
+	}
+	if !apiURL.IsAbs() || apiURL.Host == "" {
+		return nil, fmt.Errorf("apiUrl in session is not an absolute URL: %q", session.ApiUrl)
+	}
+
 	return &session, nil
 }
 
+// decodeMethodResponse decodes the payload of the response to the method call
+// into out. If the server answered the call with a method-level error, a
+// *MethodError is returned instead.
+func decodeMethodResponse(res *APIResponse, call MethodCall, out interface{}) error {
+	mr, err := res.Response(call.Payload2)
+	if err != nil {
+		return fmt.Errorf("%s: %w", call.MethodName, err)
+	}
+
+	if mr.MethodName == "error" {
+		methodErr := &MethodError{
+			MethodName: call.MethodName,
+			CallID:     mr.Payload2,
+		}
+		if err := mr.Decode(methodErr); err != nil {
+			return fmt.Errorf("%s: decoding error response: %w", call.MethodName, err)
+		}
+
+		return methodErr
+	}
+
+	if mr.MethodName != call.MethodName {
+		return fmt.Errorf("%s: unexpected %s response to method call %q", call.MethodName, mr.MethodName, mr.Payload2)
+	}
+
+	if err := mr.Decode(out); err != nil {
+		return fmt.Errorf("%s: decoding response: %w", call.MethodName, err)
+	}
+
+	return nil
+}
+
 func (client *Client) accIDOrDefault(session Session, accID string) (string, error) {
 	if accID != "" {
 		return accID, nil
@@ -148,64 +343,254 @@ func (client *Client) CreateMaskedEmail(
 	emailPrefix string,
 	enabled bool,
 ) (*MaskedEmail, error) {
-	state := ""
-	if enabled {
-		state = "enabled"
-	}
+	return client.CreateMaskedEmailContext(context.Background(), session, accID, domain, description, emailPrefix, enabled)
+}
+
+// CreateMaskedEmailContext is like CreateMaskedEmail but uses the given
+// context for the request.
+func (client *Client) CreateMaskedEmailContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	domain string,
+	description string,
+	emailPrefix string,
+	enabled bool,
+) (*MaskedEmail, error) {
+	return client.CreateMaskedEmailFromSpecContext(ctx, session, accID, CreateSpec{
+		Domain:      domain,
+		Description: description,
+		EmailPrefix: emailPrefix,
+		Enabled:     enabled,
+	})
+}
+
+// CreateMaskedEmailFromSpec is like CreateMaskedEmail but takes all values,
+// including the url, from the spec.
+func (client *Client) CreateMaskedEmailFromSpec(
+	session Session,
+	accID string,
+	spec CreateSpec,
+) (*MaskedEmail, error) {
+	return client.CreateMaskedEmailFromSpecContext(context.Background(), session, accID, spec)
+}
 
+// CreateMaskedEmailFromSpecContext is like CreateMaskedEmailFromSpec but uses
+// the given context for the request.
+func (client *Client) CreateMaskedEmailFromSpecContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	spec CreateSpec,
+) (*MaskedEmail, error) {
 	accID, err := client.accIDOrDefault(session, accID)
 	if err != nil {
 		return nil, err
 	}
 
-	mc := MethodCall{
-		MethodName: "MaskedEmail/set",
-		Payload:    NewMethodCallCreate(accID, client.appName, domain, state, description, emailPrefix),
-		Payload2:   "0",
+	payload := spec.payload()
+	pl, err := client.setMaskedEmails(ctx, session, NewMethodCallCreateBatch(accID, map[string]CreatePayload{
+		client.appName: payload,
+	}))
+	if err != nil {
+		return nil, err
 	}
 
-	request := APIRequest{
-		Using: []string{
-			"urn:ietf:params:jmap:core",
-			MaskedEmailCapabilityURI,
-		},
-		MethodCalls: []MethodCall{mc},
+	created, err := pl.GetCreatedItem()
+	if err != nil {
+		return nil, err
 	}
 
-	res, err := client.sendRequest(session, &request)
+	fillCreated(&created, payload)
+	return &created, nil
+}
+
+// UpdateMaskedEmail applies the update options to the masked email with the
+// given ID. It returns the set response, or an error if the server did not
+// report the masked email as updated.
+func (client *Client) UpdateMaskedEmail(
+	session Session,
+	accID string,
+	emailID string,
+	updateOpts ...UpdateOption,
+) (*MethodResponseMaskedEmailSet, error) {
+	return client.UpdateMaskedEmailContext(context.Background(), session, accID, emailID, updateOpts...)
+}
+
+// UpdateMaskedEmailContext is like UpdateMaskedEmail but uses the given
+// context for the request.
+func (client *Client) UpdateMaskedEmailContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	emailID string,
+	updateOpts ...UpdateOption,
+) (*MethodResponseMaskedEmailSet, error) {
+
+	pl, err := client.UpdateMaskedEmailsContext(ctx, session, accID, map[string][]UpdateOption{
+		emailID: updateOpts,
+	})
+	if setErrs, ok := err.(SetErrors); ok && len(setErrs) == 1 {
+		return nil, setErrs[0]
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	var pl MethodResponseMaskedEmailSet
-	err = mapstructure.Decode(res.MethodResponsesParsed[0].Payload, &pl)
+	return pl, nil
+}
+
+// CreateSpec describes a single masked email to create with
+// CreateMaskedEmails.
+type CreateSpec struct {
+	Domain      string
+	Description string
+	EmailPrefix string
+	// URL is optional, eg. the login page of the site.
+	URL string
+	// Enabled is false to only create a pending masked email.
+	Enabled bool
+}
+
+// payload returns the create payload for the spec.
+func (spec CreateSpec) payload() CreatePayload {
+	state := ""
+	if spec.Enabled {
+		state = "enabled"
+	}
+
+	payload := NewCreatePayload(spec.Domain, state, spec.Description, spec.EmailPrefix)
+	payload.URL = spec.URL
+
+	return payload
+}
+
+// CreateMaskedEmails creates many masked emails in a single MaskedEmail/set
+// call. The result has one entry per spec, in order, which is nil for specs
+// the server rejected. Rejections are returned as SetErrors along with the
+// masked emails that were created.
+func (client *Client) CreateMaskedEmails(
+	session Session,
+	accID string,
+	specs []CreateSpec,
+) ([]*MaskedEmail, error) {
+	return client.CreateMaskedEmailsContext(context.Background(), session, accID, specs)
+}
+
+// CreateMaskedEmailsContext is like CreateMaskedEmails but uses the given
+// context for the request.
+func (client *Client) CreateMaskedEmailsContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	specs []CreateSpec,
+) ([]*MaskedEmail, error) {
+	accID, err := client.accIDOrDefault(session, accID)
 	if err != nil {
 		return nil, err
 	}
 
-	created, err := pl.GetCreatedItem()
+	creationIDs := make([]string, len(specs))
+	payloads := make(map[string]CreatePayload, len(specs))
+	for i, spec := range specs {
+		creationIDs[i] = fmt.Sprintf("%s-%d", client.appName, i)
+		payloads[creationIDs[i]] = spec.payload()
+	}
+
+	pl, err := client.setMaskedEmails(ctx, session, NewMethodCallCreateBatch(accID, payloads))
 	if err != nil {
 		return nil, err
 	}
 
-	return &created, nil
+	out := make([]*MaskedEmail, len(specs))
+	var setErrs SetErrors
+	for i, creationID := range creationIDs {
+		if created, ok := pl.Created[creationID]; ok {
+			fillCreated(&created, payloads[creationID])
+			out[i] = &created
+			continue
+		}
+
+		setErrs = append(setErrs, setErrorOrMissing(pl.NotCreated, creationID, "not created"))
+	}
+
+	if len(setErrs) > 0 {
+		return out, setErrs
+	}
+
+	return out, nil
 }
 
-func (client *Client) UpdateMaskedEmail(
+// UpdateMaskedEmails applies updates to many masked emails, keyed by ID, in a
+// single MaskedEmail/set call. Rejected updates are returned as SetErrors
+// along with the set response.
+func (client *Client) UpdateMaskedEmails(
 	session Session,
 	accID string,
-	emailID string,
-	updateOpts ...UpdateOption,
+	updates map[string][]UpdateOption,
 ) (*MethodResponseMaskedEmailSet, error) {
+	return client.UpdateMaskedEmailsContext(context.Background(), session, accID, updates)
+}
 
+// UpdateMaskedEmailsContext is like UpdateMaskedEmails but uses the given
+// context for the request.
+func (client *Client) UpdateMaskedEmailsContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	updates map[string][]UpdateOption,
+) (*MethodResponseMaskedEmailSet, error) {
 	accID, err := client.accIDOrDefault(session, accID)
 	if err != nil {
 		return nil, err
 	}
 
-	// handle more than one with a value at once?
-	var payload MethodCallUpdate = NewMethodCallUpdate(accID, emailID, updateOpts...)
+	pl, err := client.setMaskedEmails(ctx, session, NewMethodCallUpdateBatch(accID, updates))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(updates))
+	for id := range updates {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var setErrs SetErrors
+	for _, id := range ids {
+		if _, ok := pl.Updated[id]; ok {
+			continue
+		}
+
+		setErrs = append(setErrs, setErrorOrMissing(pl.NotUpdated, id, "not updated"))
+	}
+
+	if len(setErrs) > 0 {
+		return pl, setErrs
+	}
+
+	return pl, nil
+}
+
+// fillCreated completes a created masked email with the requested values, as
+// the server only returns the properties it set or changed itself.
+func fillCreated(created *MaskedEmail, payload CreatePayload) {
+	if created.Domain == "" {
+		created.Domain = payload.Domain
+	}
+	if created.Description == "" {
+		created.Description = payload.Description
+	}
+	if created.State == "" {
+		created.State = MaskedEmailState(payload.State)
+	}
+	if created.URL == "" {
+		created.URL = payload.URL
+	}
+}
 
+// setMaskedEmails sends a single MaskedEmail/set call with the given payload.
+func (client *Client) setMaskedEmails(ctx context.Context, session Session, payload interface{}) (*MethodResponseMaskedEmailSet, error) {
 	r := MethodCall{
 		MethodName: "MaskedEmail/set",
 		Payload:    payload,
@@ -220,47 +605,142 @@ func (client *Client) UpdateMaskedEmail(
 		MethodCalls: []MethodCall{r},
 	}
 
-	res, err := client.sendRequest(session, &apiRequest)
+	res, err := client.sendRequest(ctx, session, &apiRequest)
 	if err != nil {
 		return nil, err
 	}
 
 	var pl MethodResponseMaskedEmailSet
-	err = mapstructure.Decode(res.MethodResponsesParsed[0].Payload, &pl)
+	err = decodeMethodResponse(res, r, &pl)
 	if err != nil {
 		return nil, err
 	}
 
-	// TODO: fix return value
-	pl.GetCreatedItem()
+	return &pl, nil
+}
 
-	return nil, nil
+// IsMaskedEmailID reports whether s looks like a masked email ID (eg.
+// "masked-12345") rather than an email address.
+func IsMaskedEmailID(s string) bool {
+	return s != "" && !strings.Contains(s, "@")
 }
 
+// LookupMaskedEmailID returns the ID of the given masked email address. If
+// `email` already is an ID, it is returned as-is without a request.
 func (client *Client) LookupMaskedEmailID(
 	session Session,
 	accID string,
 	email string,
 ) (string, error) {
-	allAliases, err := client.GetAllMaskedEmails(session, accID, true)
+	return client.LookupMaskedEmailIDContext(context.Background(), session, accID, email)
+}
+
+// LookupMaskedEmailIDContext is like LookupMaskedEmailID but uses the given
+// context for the request.
+func (client *Client) LookupMaskedEmailIDContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	email string,
+) (string, error) {
+	if IsMaskedEmailID(email) {
+		return email, nil
+	}
+
+	alias, err := client.GetMaskedEmailContext(ctx, session, accID, email)
 	if err != nil {
 		return "", err
 	}
 
-	// find the alias to disable
-	var alias *MaskedEmail
-	for _, a := range allAliases {
-		if a.Email == email {
-			alias = a
-			break
+	return alias.ID, nil
+}
+
+// LookupMaskedEmailIDs returns the IDs for the given masked email addresses or
+// IDs, in order. All masked emails are listed at most once, and only if any
+// addresses were given.
+func (client *Client) LookupMaskedEmailIDs(
+	session Session,
+	accID string,
+	emailsOrIDs []string,
+) ([]string, error) {
+	return client.LookupMaskedEmailIDsContext(context.Background(), session, accID, emailsOrIDs)
+}
+
+// LookupMaskedEmailIDsContext is like LookupMaskedEmailIDs but uses the given
+// context for the request.
+func (client *Client) LookupMaskedEmailIDsContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	emailsOrIDs []string,
+) ([]string, error) {
+	var byEmail map[string]string
+	ids := make([]string, len(emailsOrIDs))
+
+	for i, emailOrID := range emailsOrIDs {
+		if IsMaskedEmailID(emailOrID) {
+			ids[i] = emailOrID
+			continue
+		}
+
+		if byEmail == nil {
+			allAliases, err := client.GetAllMaskedEmailsContext(ctx, session, accID, true)
+			if err != nil {
+				return nil, err
+			}
+
+			byEmail = make(map[string]string, len(allAliases))
+			for _, a := range allAliases {
+				byEmail[a.Email] = a.ID
+			}
 		}
+
+		id, ok := byEmail[emailOrID]
+		if !ok {
+			return nil, &NotFoundError{EmailOrID: emailOrID}
+		}
+		ids[i] = id
+	}
+
+	return ids, nil
+}
+
+// GetMaskedEmail returns a single masked email by ID or email address. IDs are
+// fetched directly, addresses require listing all masked emails.
+func (client *Client) GetMaskedEmail(
+	session Session,
+	accID string,
+	emailOrID string,
+) (*MaskedEmail, error) {
+	return client.GetMaskedEmailContext(context.Background(), session, accID, emailOrID)
+}
+
+// GetMaskedEmailContext is like GetMaskedEmail but uses the given context for
+// the request.
+func (client *Client) GetMaskedEmailContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	emailOrID string,
+) (*MaskedEmail, error) {
+	var candidates []*MaskedEmail
+	var err error
+	if IsMaskedEmailID(emailOrID) {
+		candidates, err = client.GetMaskedEmailsContext(ctx, session, accID, []string{emailOrID})
+	} else {
+		candidates, err = client.GetAllMaskedEmailsContext(ctx, session, accID, true)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if alias == nil {
-		return "", errors.New(fmt.Sprintf("maskedemail %s not found", email))
+	for _, a := range candidates {
+		if a.ID == emailOrID || a.Email == emailOrID {
+			return a, nil
+		}
 	}
 
-	return alias.ID, nil
+	return nil, &NotFoundError{EmailOrID: emailOrID}
 }
 
 func (client *Client) EnableMaskedEmail(
@@ -268,13 +748,23 @@ func (client *Client) EnableMaskedEmail(
 	accID string,
 	email string,
 ) (*MethodResponseMaskedEmailSet, error) {
-	emailID, err := client.LookupMaskedEmailID(session, accID, email)
+	return client.EnableMaskedEmailContext(context.Background(), session, accID, email)
+}
 
+// EnableMaskedEmailContext is like EnableMaskedEmail but uses the given context for
+// the requests.
+func (client *Client) EnableMaskedEmailContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	email string,
+) (*MethodResponseMaskedEmailSet, error) {
+	emailID, err := client.LookupMaskedEmailIDContext(ctx, session, accID, email)
 	if err != nil {
 		return nil, err
 	}
 
-	return client.UpdateMaskedEmail(session, accID, emailID, WithUpdateState(MaskedEmailStateEnabled))
+	return client.UpdateMaskedEmailContext(ctx, session, accID, emailID, WithUpdateState(MaskedEmailStateEnabled))
 }
 
 func (client *Client) DisableMaskedEmail(
@@ -282,14 +772,23 @@ func (client *Client) DisableMaskedEmail(
 	accID string,
 	email string,
 ) (*MethodResponseMaskedEmailSet, error) {
+	return client.DisableMaskedEmailContext(context.Background(), session, accID, email)
+}
 
-	emailID, err := client.LookupMaskedEmailID(session, accID, email)
-
+// DisableMaskedEmailContext is like DisableMaskedEmail but uses the given context for
+// the requests.
+func (client *Client) DisableMaskedEmailContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	email string,
+) (*MethodResponseMaskedEmailSet, error) {
+	emailID, err := client.LookupMaskedEmailIDContext(ctx, session, accID, email)
 	if err != nil {
 		return nil, err
 	}
 
-	return client.UpdateMaskedEmail(session, accID, emailID, WithUpdateState(MaskedEmailStateDisabled))
+	return client.UpdateMaskedEmailContext(ctx, session, accID, emailID, WithUpdateState(MaskedEmailStateDisabled))
 }
 
 func (client *Client) DeleteMaskedEmail(
@@ -297,14 +796,53 @@ func (client *Client) DeleteMaskedEmail(
 	accID string,
 	email string,
 ) (*MethodResponseMaskedEmailSet, error) {
+	return client.DeleteMaskedEmailContext(context.Background(), session, accID, email)
+}
 
-	emailID, err := client.LookupMaskedEmailID(session, accID, email)
+// DeleteMaskedEmailContext is like DeleteMaskedEmail but uses the given context for
+// the requests.
+func (client *Client) DeleteMaskedEmailContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	email string,
+) (*MethodResponseMaskedEmailSet, error) {
+	emailID, err := client.LookupMaskedEmailIDContext(ctx, session, accID, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.UpdateMaskedEmailContext(ctx, session, accID, emailID, WithUpdateState(MaskedEmailStateDeleted))
+}
+
+// ConfirmMaskedEmail enables a pending masked email, so that it is kept
+// instead of expiring. Returns an error if the masked email isn't pending.
+func (client *Client) ConfirmMaskedEmail(
+	session Session,
+	accID string,
+	email string,
+) (*MethodResponseMaskedEmailSet, error) {
+	return client.ConfirmMaskedEmailContext(context.Background(), session, accID, email)
+}
 
+// ConfirmMaskedEmailContext is like ConfirmMaskedEmail but uses the given
+// context for the requests.
+func (client *Client) ConfirmMaskedEmailContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	email string,
+) (*MethodResponseMaskedEmailSet, error) {
+	alias, err := client.GetMaskedEmailContext(ctx, session, accID, email)
 	if err != nil {
 		return nil, err
 	}
 
-	return client.UpdateMaskedEmail(session, accID, emailID, WithUpdateState(MaskedEmailStateDeleted))
+	if alias.State != MaskedEmailStatePending {
+		return nil, fmt.Errorf("maskedemail %s is not pending (state: %s)", email, alias.State)
+	}
+
+	return client.UpdateMaskedEmailContext(ctx, session, accID, alias.ID, WithUpdateState(MaskedEmailStateEnabled))
 }
 
 func (client *Client) UpdateInfo(
@@ -313,29 +851,92 @@ func (client *Client) UpdateInfo(
 	email string,
 	updateOpts ...UpdateOption,
 ) (*MethodResponseMaskedEmailSet, error) {
+	return client.UpdateInfoContext(context.Background(), session, accID, email, updateOpts...)
+}
 
-	emailID, err := client.LookupMaskedEmailID(session, accID, email)
-
+// UpdateInfoContext is like UpdateInfo but uses the given context for the
+// requests.
+func (client *Client) UpdateInfoContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	email string,
+	updateOpts ...UpdateOption,
+) (*MethodResponseMaskedEmailSet, error) {
+	emailID, err := client.LookupMaskedEmailIDContext(ctx, session, accID, email)
 	if err != nil {
 		return nil, err
 	}
 
-	return client.UpdateMaskedEmail(session, accID, emailID, updateOpts...)
+	return client.UpdateMaskedEmailContext(ctx, session, accID, emailID, updateOpts...)
 }
 
 func (client *Client) GetAllMaskedEmails(
 	session Session,
 	accID string,
 	includeDeleted bool,
+) ([]*MaskedEmail, error) {
+	return client.GetAllMaskedEmailsContext(context.Background(), session, accID, includeDeleted)
+}
+
+// GetAllMaskedEmailsContext is like GetAllMaskedEmails but uses the given
+// context for the request.
+func (client *Client) GetAllMaskedEmailsContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	includeDeleted bool,
 ) ([]*MaskedEmail, error) {
 	accID, err := client.accIDOrDefault(session, accID)
 	if err != nil {
 		return nil, err
 	}
 
+	pl, err := client.getMaskedEmails(ctx, session, NewMethodCallGetAll(accID))
+	if err != nil {
+		return nil, err
+	}
+
+	out := []*MaskedEmail{}
+	for _, item := range pl.List {
+		// skip deleted masked emails unless flag to show is passed
+		if item.State == "deleted" && !includeDeleted {
+			continue
+		}
+
+		out = append(out, item)
+	}
+
+	return out, nil
+}
+
+// GetMaskedEmailChanges returns the IDs of the masked emails created, updated
+// and destroyed since the given state. The server may limit the number of
+// changes returned, see MethodResponseChanges.HasMoreChanges.
+func (client *Client) GetMaskedEmailChanges(
+	session Session,
+	accID string,
+	sinceState string,
+) (*MethodResponseChanges, error) {
+	return client.GetMaskedEmailChangesContext(context.Background(), session, accID, sinceState)
+}
+
+// GetMaskedEmailChangesContext is like GetMaskedEmailChanges but uses the
+// given context for the request.
+func (client *Client) GetMaskedEmailChangesContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	sinceState string,
+) (*MethodResponseChanges, error) {
+	accID, err := client.accIDOrDefault(session, accID)
+	if err != nil {
+		return nil, err
+	}
+
 	r := MethodCall{
-		MethodName: "MaskedEmail/get",
-		Payload:    NewMethodCallGetAll(accID),
+		MethodName: "MaskedEmail/changes",
+		Payload:    NewMethodCallChanges(accID, sinceState, 0),
 		Payload2:   "0",
 	}
 
@@ -347,26 +948,82 @@ func (client *Client) GetAllMaskedEmails(
 		MethodCalls: []MethodCall{r},
 	}
 
-	res, err := client.sendRequest(session, &apiRequest)
+	res, err := client.sendRequest(ctx, session, &apiRequest)
 	if err != nil {
 		return nil, err
 	}
 
-	var pl MethodResponseGetAll
-	err = mapstructure.Decode(res.MethodResponsesParsed[0].Payload, &pl)
+	var pl MethodResponseChanges
+	err = decodeMethodResponse(res, r, &pl)
 	if err != nil {
 		return nil, err
 	}
 
-	out := []*MaskedEmail{}
-	for _, item := range pl.List {
-		// skip deleted masked emails unless flag to show is passed
-		if item.State == "deleted" && !includeDeleted {
-			continue
-		}
+	return &pl, nil
+}
 
-		out = append(out, item)
+// GetMaskedEmails fetches the masked emails with the given IDs, including
+// deleted ones. IDs unknown to the server are omitted from the result.
+func (client *Client) GetMaskedEmails(
+	session Session,
+	accID string,
+	ids []string,
+) ([]*MaskedEmail, error) {
+	return client.GetMaskedEmailsContext(context.Background(), session, accID, ids)
+}
+
+// GetMaskedEmailsContext is like GetMaskedEmails but uses the given context
+// for the request.
+func (client *Client) GetMaskedEmailsContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	ids []string,
+) ([]*MaskedEmail, error) {
+	accID, err := client.accIDOrDefault(session, accID)
+	if err != nil {
+		return nil, err
 	}
 
-	return out, nil
+	if len(ids) == 0 {
+		return []*MaskedEmail{}, nil
+	}
+
+	// all properties, so the ones unknown to this package end up in Extra
+	pl, err := client.getMaskedEmails(ctx, session, NewMethodCallGet(accID, ids, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	return pl.List, nil
+}
+
+// getMaskedEmails sends a single MaskedEmail/get call with the given payload.
+func (client *Client) getMaskedEmails(ctx context.Context, session Session, payload interface{}) (*MethodResponseGetAll, error) {
+	r := MethodCall{
+		MethodName: "MaskedEmail/get",
+		Payload:    payload,
+		Payload2:   "0",
+	}
+
+	apiRequest := APIRequest{
+		Using: []string{
+			"urn:ietf:params:jmap:core",
+			MaskedEmailCapabilityURI,
+		},
+		MethodCalls: []MethodCall{r},
+	}
+
+	res, err := client.sendRequest(ctx, session, &apiRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var pl MethodResponseGetAll
+	err = decodeMethodResponse(res, r, &pl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pl, nil
 }
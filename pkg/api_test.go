@@ -0,0 +1,44 @@
+package pkg
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+type nopTransport struct{}
+
+func (nopTransport) RoundTrip(*http.Request) (*http.Response, error) { return nil, nil }
+
+func TestClientOptionsOrder(t *testing.T) {
+	httpClient := &http.Client{Timeout: time.Minute}
+
+	for _, tt := range []struct {
+		name string
+		opts []ClientOption
+	}{
+		{"http client first", []ClientOption{WithHTTPClient(httpClient), WithTransport(nopTransport{}), WithTimeout(time.Second)}},
+		{"http client last", []ClientOption{WithTransport(nopTransport{}), WithTimeout(time.Second), WithHTTPClient(httpClient)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient("token", "app", "", tt.opts...)
+
+			if _, ok := client.httpClient.Transport.(nopTransport); !ok {
+				t.Errorf("transport not applied: %T", client.httpClient.Transport)
+			}
+			if client.httpClient.Timeout != time.Second {
+				t.Errorf("got timeout %v, want 1s", client.httpClient.Timeout)
+			}
+			if httpClient.Timeout != time.Minute || httpClient.Transport != nil {
+				t.Error("the caller's http client was modified")
+			}
+		})
+	}
+}
+
+func TestClientZeroTimeout(t *testing.T) {
+	client := NewClient("token", "app", "", WithTimeout(0), WithHTTPClient(&http.Client{Timeout: time.Minute}))
+	if client.httpClient.Timeout != 0 {
+		t.Errorf("got timeout %v, want none", client.httpClient.Timeout)
+	}
+}
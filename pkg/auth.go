@@ -0,0 +1,30 @@
+package pkg
+
+import "net/http"
+
+// Authenticator adds credentials to requests sent to the API.
+type Authenticator interface {
+	// Authorize sets the credentials on the request, usually in the
+	// Authorization header.
+	Authorize(req *http.Request) error
+}
+
+// Refresher is implemented by authenticators whose credentials can be
+// renewed. The client calls Refresh with the rejected request and retries it
+// once when the server responds with 401 Unauthorized.
+type Refresher interface {
+	// Refresh renews the credentials. Implementations should skip refreshing
+	// if the credentials changed since rejected was authorized, eg. because a
+	// concurrent request already refreshed them.
+	Refresh(rejected *http.Request) error
+}
+
+// BearerToken authenticates with a static API token.
+type BearerToken string
+
+var _ Authenticator = BearerToken("")
+
+func (t BearerToken) Authorize(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+// MaskedEmailCache is a local copy of the masked emails of an account, kept
+// up to date incrementally with SyncMaskedEmailCache. It can be stored as
+// JSON.
+type MaskedEmailCache struct {
+	AccountID string `json:"accountId"`
+	// State is the MaskedEmail state string of the server at the last sync.
+	State    string                  `json:"state"`
+	SyncedAt time.Time               `json:"syncedAt"`
+	Emails   map[string]*MaskedEmail `json:"emails"`
+}
+
+// CacheSyncResult summarizes the changes applied by a sync.
+type CacheSyncResult struct {
+	// Full is set if all masked emails were fetched, because the cache was
+	// empty or the server couldn't calculate the changes since its state.
+	Full      bool
+	Created   int
+	Updated   int
+	Destroyed int
+}
+
+// List returns the cached masked emails, oldest first.
+func (c *MaskedEmailCache) List(includeDeleted bool) []*MaskedEmail {
+	out := make([]*MaskedEmail, 0, len(c.Emails))
+	for _, email := range c.Emails {
+		if email.State == MaskedEmailStateDeleted && !includeDeleted {
+			continue
+		}
+
+		out = append(out, email)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+
+	return out
+}
+
+// Lookup returns the cached masked email with the given ID or address.
+func (c *MaskedEmailCache) Lookup(emailOrID string) (*MaskedEmail, bool) {
+	if email, ok := c.Emails[emailOrID]; ok {
+		return email, true
+	}
+
+	for _, email := range c.Emails {
+		if email.Email == emailOrID {
+			return email, true
+		}
+	}
+
+	return nil, false
+}
+
+// SyncMaskedEmailCache updates the cache with the changes since its state.
+// All masked emails are fetched if the cache is empty or belongs to another
+// account.
+func (client *Client) SyncMaskedEmailCache(
+	session Session,
+	accID string,
+	cache *MaskedEmailCache,
+) (*CacheSyncResult, error) {
+	return client.SyncMaskedEmailCacheContext(context.Background(), session, accID, cache)
+}
+
+// SyncMaskedEmailCacheContext is like SyncMaskedEmailCache but uses the given
+// context for the requests.
+func (client *Client) SyncMaskedEmailCacheContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	cache *MaskedEmailCache,
+) (*CacheSyncResult, error) {
+	accID, err := client.accIDOrDefault(session, accID)
+	if err != nil {
+		return nil, err
+	}
+
+	if cache.AccountID != accID || cache.State == "" || cache.Emails == nil {
+		return client.fillMaskedEmailCache(ctx, session, accID, cache)
+	}
+
+	result := &CacheSyncResult{}
+	for {
+		changes, err := client.GetMaskedEmailChangesContext(ctx, session, accID, cache.State)
+
+		var methodErr *MethodError
+		if errors.As(err, &methodErr) && methodErr.Type == "cannotCalculateChanges" {
+			return client.fillMaskedEmailCache(ctx, session, accID, cache)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		changed := append(append([]string{}, changes.Created...), changes.Updated...)
+		emails, err := client.GetMaskedEmailsContext(ctx, session, accID, changed)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, email := range emails {
+			cache.Emails[email.ID] = email
+		}
+		for _, id := range changes.Destroyed {
+			delete(cache.Emails, id)
+		}
+
+		result.Created += len(changes.Created)
+		result.Updated += len(changes.Updated)
+		result.Destroyed += len(changes.Destroyed)
+
+		cache.State = changes.NewState
+		if !changes.HasMoreChanges {
+			break
+		}
+	}
+
+	cache.SyncedAt = time.Now().UTC()
+	return result, nil
+}
+
+// fillMaskedEmailCache replaces the contents of the cache with all masked
+// emails of the account.
+func (client *Client) fillMaskedEmailCache(ctx context.Context, session Session, accID string, cache *MaskedEmailCache) (*CacheSyncResult, error) {
+	pl, err := client.getMaskedEmails(ctx, session, NewMethodCallGetAll(accID))
+	if err != nil {
+		return nil, err
+	}
+
+	cache.AccountID = accID
+	cache.State = pl.State
+	cache.SyncedAt = time.Now().UTC()
+	cache.Emails = make(map[string]*MaskedEmail, len(pl.List))
+	for _, email := range pl.List {
+		cache.Emails[email.ID] = email
+	}
+
+	return &CacheSyncResult{Full: true, Created: len(pl.List)}, nil
+}
@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// NormalizeDomain reduces a domain or URL to its registrable domain (eTLD+1)
+// according to the public suffix list, so "https://www.Example.co.uk/login/"
+// becomes "example.co.uk". Hosts without a registrable domain, like
+// "localhost", IP addresses or bare public suffixes, are only lowercased and
+// stripped of the scheme, port, path and "www.".
+func NormalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" {
+		return ""
+	}
+
+	if strings.Contains(domain, "://") {
+		if u, err := url.Parse(domain); err == nil && u.Host != "" {
+			domain = u.Host
+		}
+	}
+
+	// drop the path, userinfo and port of scheme-less urls
+	if i := strings.IndexAny(domain, "/?#"); i >= 0 {
+		domain = domain[:i]
+	}
+	if i := strings.LastIndex(domain, "@"); i >= 0 {
+		domain = domain[i+1:]
+	}
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+
+	domain = strings.Trim(domain, ".[]")
+	domain = strings.TrimPrefix(domain, "www.")
+
+	if net.ParseIP(domain) != nil {
+		return domain
+	}
+
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+
+	return registrable
+}
+
+// GetOrCreateMaskedEmail returns an existing masked email for the domain of
+// the spec, compared with NormalizeDomain, or creates one if there is none.
+// Enabled masked emails are preferred, the most recently created one wins if
+// there are several. With reenable, a disabled or pending masked email is
+// enabled and returned instead of creating a new one.
+//
+// created reports whether a new masked email was created. Specs without a
+// domain always create a new masked email.
+func (client *Client) GetOrCreateMaskedEmail(
+	session Session,
+	accID string,
+	spec CreateSpec,
+	reenable bool,
+) (email *MaskedEmail, created bool, err error) {
+	return client.GetOrCreateMaskedEmailContext(context.Background(), session, accID, spec, reenable)
+}
+
+// GetOrCreateMaskedEmailContext is like GetOrCreateMaskedEmail but uses the
+// given context for the requests.
+func (client *Client) GetOrCreateMaskedEmailContext(
+	ctx context.Context,
+	session Session,
+	accID string,
+	spec CreateSpec,
+	reenable bool,
+) (email *MaskedEmail, created bool, err error) {
+	if domain := NormalizeDomain(spec.Domain); domain != "" {
+		emails, err := client.GetAllMaskedEmailsContext(ctx, session, accID, false)
+		if err != nil {
+			return nil, false, err
+		}
+
+		var enabled, other *MaskedEmail
+		for _, e := range emails {
+			if NormalizeDomain(e.Domain) != domain {
+				continue
+			}
+
+			switch e.State {
+			case MaskedEmailStateEnabled:
+				if enabled == nil || e.CreatedAt.After(enabled.CreatedAt) {
+					enabled = e
+				}
+			case MaskedEmailStateDisabled, MaskedEmailStatePending:
+				if other == nil || e.CreatedAt.After(other.CreatedAt) {
+					other = e
+				}
+			}
+		}
+
+		if enabled != nil {
+			return enabled, false, nil
+		}
+
+		if other != nil && reenable {
+			_, err := client.UpdateMaskedEmailContext(ctx, session, accID, other.ID, WithUpdateState(MaskedEmailStateEnabled))
+			if err != nil {
+				return nil, false, err
+			}
+
+			other.State = MaskedEmailStateEnabled
+			return other, false, nil
+		}
+	}
+
+	email, err = client.CreateMaskedEmailFromSpecContext(ctx, session, accID, spec)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return email, true, nil
+}
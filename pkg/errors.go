@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// requestErrorPrefix is the prefix of all JMAP request-level error types.
+//
+// https://jmap.io/spec-core.html#request-level-errors
+const requestErrorPrefix = "urn:ietf:params:jmap:error:"
+
+// maxErrorBodyLen limits how much of an unexpected response body is included
+// in error messages.
+const maxErrorBodyLen = 512
+
+// HTTPError is returned when the server responds with a non-2xx status code.
+type HTTPError struct {
+	// StatusCode is the HTTP status code, eg. 401.
+	StatusCode int
+	// Status is the HTTP status line, eg. "401 Unauthorized".
+	Status string
+	// Body is the raw response body.
+	Body []byte
+	// Problem is the JMAP request-level error contained in the body, if any.
+	Problem *RequestError
+}
+
+func (e *HTTPError) Error() string {
+	if e.Problem != nil {
+		return fmt.Sprintf("http %s: %v", e.Status, e.Problem)
+	}
+
+	body := strings.TrimSpace(string(e.Body))
+	if len(body) > maxErrorBodyLen {
+		body = body[:maxErrorBodyLen] + "..."
+	}
+	if body == "" {
+		return fmt.Sprintf("http %s", e.Status)
+	}
+
+	return fmt.Sprintf("http %s: %s", e.Status, body)
+}
+
+// Unwrap allows matching the request-level problem details with errors.As.
+func (e *HTTPError) Unwrap() error {
+	if e.Problem == nil {
+		return nil
+	}
+
+	return e.Problem
+}
+
+// RequestError is a JMAP request-level error, returned by the server as
+// RFC 7807 problem details with a type of "urn:ietf:params:jmap:error:*".
+//
+// https://jmap.io/spec-core.html#request-level-errors
+type RequestError struct {
+	// Type is the problem type URI, eg.
+	// "urn:ietf:params:jmap:error:unknownCapability".
+	Type string `json:"type"`
+	// Status is the HTTP status code of the response.
+	Status int `json:"status"`
+	// Detail is a human readable explanation of the problem.
+	Detail string `json:"detail"`
+	// Limit is the name of the exceeded limit for "limit" errors.
+	Limit string `json:"limit,omitempty"`
+}
+
+func (e *RequestError) Error() string {
+	msg := strings.TrimPrefix(e.Type, requestErrorPrefix)
+	if e.Limit != "" {
+		msg += " (" + e.Limit + ")"
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+
+	return msg
+}
+
+// MethodError is a JMAP method-level error, returned by the server in place of
+// a method response as ["error", {"type": ..., "description": ...}, callID].
+//
+// https://jmap.io/spec-core.html#method-level-errors
+type MethodError struct {
+	// MethodName is the method that was called, eg. "MaskedEmail/set".
+	MethodName string `json:"-"`
+	// CallID is the method call ID the error belongs to.
+	CallID string `json:"-"`
+	// Type is the error type, eg. "invalidArguments".
+	Type string `json:"type"`
+	// Description is an optional human readable explanation of the error.
+	Description string `json:"description"`
+}
+
+func (e *MethodError) Error() string {
+	msg := fmt.Sprintf("%s: %s", e.MethodName, e.Type)
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+
+	return msg
+}
+
+// SetError describes why the server rejected a single create, update or
+// destroy within a MaskedEmail/set call, eg. an invalid email prefix.
+//
+// https://jmap.io/spec-core.html#set
+type SetError struct {
+	// ID is the creation ID or masked email ID the error belongs to.
+	ID string `json:"-"`
+	// Type is the error type, eg. "invalidProperties" or "overQuota".
+	Type string `json:"type"`
+	// Description is an optional human readable explanation of the error.
+	Description string `json:"description"`
+	// Properties lists the offending properties for "invalidProperties"
+	// errors.
+	Properties []string `json:"properties"`
+}
+
+func (e *SetError) Error() string {
+	msg := e.Type
+	if len(e.Properties) > 0 {
+		msg += " (" + strings.Join(e.Properties, ", ") + ")"
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	if e.ID != "" {
+		msg = e.ID + ": " + msg
+	}
+
+	return msg
+}
+
+// SetErrors collects the rejected items of a batch MaskedEmail/set call.
+type SetErrors []*SetError
+
+func (e SetErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, setErr := range e {
+		msgs[i] = setErr.Error()
+	}
+
+	return fmt.Sprintf("%d change(s) rejected: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// newHTTPError builds an *HTTPError for a non-2xx response, decoding JMAP
+// problem details from the body where present.
+func newHTTPError(statusCode int, status string, body []byte) *HTTPError {
+	httpErr := &HTTPError{
+		StatusCode: statusCode,
+		Status:     status,
+		Body:       body,
+	}
+
+	var problem RequestError
+	if err := json.Unmarshal(body, &problem); err == nil && strings.HasPrefix(problem.Type, requestErrorPrefix) {
+		if problem.Status == 0 {
+			problem.Status = statusCode
+		}
+		httpErr.Problem = &problem
+	}
+
+	return httpErr
+}
+
+// OAuthError is an error response of an OAuth authorization server.
+//
+// https://www.rfc-editor.org/rfc/rfc6749#section-5.2
+type OAuthError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int `json:"-"`
+	// Code is the error code, eg. "invalid_grant".
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("oauth %s: %s", e.Code, e.Description)
+	}
+
+	return fmt.Sprintf("oauth %s", e.Code)
+}
+
+// NotFoundError is returned when looking up a masked email that doesn't
+// exist.
+type NotFoundError struct {
+	// EmailOrID is the address or ID that was looked up.
+	EmailOrID string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("maskedemail %s not found", e.EmailOrID)
+}
@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaskedEmailFilter selects masked emails by their properties. Zero valued
+// fields match all masked emails.
+type MaskedEmailFilter struct {
+	// States matches any of the given states.
+	States []MaskedEmailState
+	// Domain matches forDomain case-insensitively. It is a glob pattern (see
+	// path.Match) if it contains any of "*?[", otherwise a substring.
+	Domain string
+	// Description matches the description.
+	Description *regexp.Regexp
+	// CreatedBy matches createdBy case-insensitively.
+	CreatedBy string
+	// CreatedAfter and CreatedBefore limit the creation time (exclusive).
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// LastMessageAfter and LastMessageBefore limit the time of the last
+	// received message (exclusive). Masked emails that never received a
+	// message don't match if either is set.
+	LastMessageAfter  time.Time
+	LastMessageBefore time.Time
+}
+
+// Match returns true if the masked email matches all criteria of the filter.
+func (f *MaskedEmailFilter) Match(email *MaskedEmail) bool {
+	if len(f.States) > 0 {
+		found := false
+		for _, state := range f.States {
+			found = found || email.State == state
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.Domain != "" && !matchDomain(f.Domain, email.Domain) {
+		return false
+	}
+
+	if f.Description != nil && !f.Description.MatchString(strings.TrimSpace(email.Description)) {
+		return false
+	}
+
+	if f.CreatedBy != "" && !strings.EqualFold(f.CreatedBy, email.CreatedBy) {
+		return false
+	}
+
+	if !matchTime(email.CreatedAt, f.CreatedAfter, f.CreatedBefore) {
+		return false
+	}
+
+	if !matchTime(timeOrZero(email.LastMessageAt), f.LastMessageAfter, f.LastMessageBefore) {
+		return false
+	}
+
+	return true
+}
+
+// FilterMaskedEmails returns the masked emails matching the filter, keeping
+// their order.
+func FilterMaskedEmails(emails []*MaskedEmail, filter MaskedEmailFilter) []*MaskedEmail {
+	out := []*MaskedEmail{}
+	for _, email := range emails {
+		if filter.Match(email) {
+			out = append(out, email)
+		}
+	}
+
+	return out
+}
+
+func matchDomain(pattern, domain string) bool {
+	pattern = strings.ToLower(pattern)
+	domain = strings.ToLower(strings.TrimSpace(domain))
+
+	if strings.ContainsAny(pattern, "*?[") {
+		ok, err := path.Match(pattern, domain)
+		return err == nil && ok
+	}
+
+	return strings.Contains(domain, pattern)
+}
+
+func matchTime(t time.Time, after time.Time, before time.Time) bool {
+	if after.IsZero() && before.IsZero() {
+		return true
+	}
+	if t.IsZero() {
+		return false
+	}
+
+	return (after.IsZero() || t.After(after)) && (before.IsZero() || t.Before(before))
+}
+
+// SortField is a masked email property to sort by.
+type SortField string
+
+const (
+	SortByEmail         SortField = "email"
+	SortByDomain        SortField = "forDomain"
+	SortByDescription   SortField = "description"
+	SortByState         SortField = "state"
+	SortByID            SortField = "id"
+	SortByCreatedBy     SortField = "createdBy"
+	SortByCreatedAt     SortField = "createdAt"
+	SortByLastMessageAt SortField = "lastMessageAt"
+)
+
+// SortFields lists all fields masked emails can be sorted by.
+var SortFields = []SortField{
+	SortByEmail,
+	SortByDomain,
+	SortByDescription,
+	SortByState,
+	SortByID,
+	SortByCreatedBy,
+	SortByCreatedAt,
+	SortByLastMessageAt,
+}
+
+// ParseSortField parses a property name, case-insensitively, into a
+// SortField. "domain" is accepted as alias for "forDomain".
+func ParseSortField(s string) (SortField, error) {
+	if strings.EqualFold(s, "domain") {
+		return SortByDomain, nil
+	}
+
+	for _, field := range SortFields {
+		if strings.EqualFold(s, string(field)) {
+			return field, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown sort field %q", s)
+}
+
+// SortMaskedEmails sorts the masked emails in place by the given field. Ties
+// keep their original order.
+func SortMaskedEmails(emails []*MaskedEmail, field SortField, descending bool) {
+	less := func(a, b *MaskedEmail) bool {
+		switch field {
+		case SortByDomain:
+			return strings.ToLower(strings.TrimSpace(a.Domain)) < strings.ToLower(strings.TrimSpace(b.Domain))
+		case SortByDescription:
+			return strings.ToLower(strings.TrimSpace(a.Description)) < strings.ToLower(strings.TrimSpace(b.Description))
+		case SortByState:
+			return a.State < b.State
+		case SortByID:
+			return a.ID < b.ID
+		case SortByCreatedBy:
+			return a.CreatedBy < b.CreatedBy
+		case SortByCreatedAt:
+			return a.CreatedAt.Before(b.CreatedAt)
+		case SortByLastMessageAt:
+			return timeOrZero(a.LastMessageAt).Before(timeOrZero(b.LastMessageAt))
+		default:
+			return a.Email < b.Email
+		}
+	}
+
+	sort.SliceStable(emails, func(i, j int) bool {
+		if descending {
+			return less(emails[j], emails[i])
+		}
+		return less(emails[i], emails[j])
+	})
+}
@@ -0,0 +1,209 @@
+package pkg
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+
+	return t
+}
+
+func datePtr(s string) *time.Time {
+	t := date(s)
+	return &t
+}
+
+// filterTestEmails are masked emails created one per month, masked-3 never
+// received a message.
+var filterTestEmails = []*MaskedEmail{
+	{ID: "masked-1", Email: "a@x.com", Domain: "github.com", Description: "GitHub", State: MaskedEmailStateEnabled, CreatedBy: "cli", CreatedAt: date("2024-01-01"), LastMessageAt: datePtr("2024-06-01")},
+	{ID: "masked-2", Email: "b@x.com", Domain: "Mail.Example.com", Description: "Newsletter ", State: MaskedEmailStateDisabled, CreatedBy: "1Password", CreatedAt: date("2024-02-01"), LastMessageAt: datePtr("2024-03-01")},
+	{ID: "masked-3", Email: "c@x.com", Domain: " example.org", Description: "", State: MaskedEmailStatePending, CreatedBy: "cli", CreatedAt: date("2024-03-01")},
+	{ID: "masked-4", Email: "d@x.com", Domain: "shop.example.com", Description: "newsletter shop", State: MaskedEmailStateDeleted, CreatedBy: "web", CreatedAt: date("2024-04-01"), LastMessageAt: datePtr("2024-04-02")},
+}
+
+func ids(emails []*MaskedEmail) []string {
+	out := []string{}
+	for _, e := range emails {
+		out = append(out, e.ID)
+	}
+
+	return out
+}
+
+func TestFilterMaskedEmails(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter MaskedEmailFilter
+		want   []string
+	}{
+		{
+			name: "zero filter matches all",
+			want: []string{"masked-1", "masked-2", "masked-3", "masked-4"},
+		},
+		{
+			name:   "states",
+			filter: MaskedEmailFilter{States: []MaskedEmailState{MaskedEmailStateDisabled, MaskedEmailStatePending}},
+			want:   []string{"masked-2", "masked-3"},
+		},
+		{
+			name:   "domain substring is case-insensitive",
+			filter: MaskedEmailFilter{Domain: "EXAMPLE"},
+			want:   []string{"masked-2", "masked-3", "masked-4"},
+		},
+		{
+			name:   "domain glob",
+			filter: MaskedEmailFilter{Domain: "*.example.com"},
+			want:   []string{"masked-2", "masked-4"},
+		},
+		{
+			name:   "domain glob matches the whole domain",
+			filter: MaskedEmailFilter{Domain: "example.*"},
+			want:   []string{"masked-3"},
+		},
+		{
+			name:   "domain glob with character class",
+			filter: MaskedEmailFilter{Domain: "[gs]*"},
+			want:   []string{"masked-1", "masked-4"},
+		},
+		{
+			name:   "invalid glob matches nothing",
+			filter: MaskedEmailFilter{Domain: "[example"},
+			want:   []string{},
+		},
+		{
+			name:   "description regexp",
+			filter: MaskedEmailFilter{Description: regexp.MustCompile(`(?i)^newsletter$`)},
+			want:   []string{"masked-2"},
+		},
+		{
+			name:   "created by is case-insensitive",
+			filter: MaskedEmailFilter{CreatedBy: "1password"},
+			want:   []string{"masked-2"},
+		},
+		{
+			name:   "created after is exclusive",
+			filter: MaskedEmailFilter{CreatedAfter: date("2024-02-01")},
+			want:   []string{"masked-3", "masked-4"},
+		},
+		{
+			name:   "created before is exclusive",
+			filter: MaskedEmailFilter{CreatedBefore: date("2024-02-01")},
+			want:   []string{"masked-1"},
+		},
+		{
+			name:   "created between",
+			filter: MaskedEmailFilter{CreatedAfter: date("2024-01-15"), CreatedBefore: date("2024-03-15")},
+			want:   []string{"masked-2", "masked-3"},
+		},
+		{
+			name:   "last message before excludes masked emails without messages",
+			filter: MaskedEmailFilter{LastMessageBefore: date("2024-05-01")},
+			want:   []string{"masked-2", "masked-4"},
+		},
+		{
+			name:   "last message after",
+			filter: MaskedEmailFilter{LastMessageAfter: date("2024-04-01")},
+			want:   []string{"masked-1", "masked-4"},
+		},
+		{
+			name: "all criteria",
+			filter: MaskedEmailFilter{
+				States:       []MaskedEmailState{MaskedEmailStateEnabled, MaskedEmailStateDeleted},
+				Domain:       "*example*",
+				CreatedAfter: date("2024-01-01"),
+			},
+			want: []string{"masked-4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(FilterMaskedEmails(filterTestEmails, tt.filter))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSortField(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SortField
+		wantErr bool
+	}{
+		{in: "email", want: SortByEmail},
+		{in: "CreatedAt", want: SortByCreatedAt},
+		{in: "forDomain", want: SortByDomain},
+		{in: "domain", want: SortByDomain},
+		{in: "lastmessageat", want: SortByLastMessageAt},
+		{in: "url", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "-email", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSortField(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortMaskedEmails(t *testing.T) {
+	tests := []struct {
+		field      SortField
+		descending bool
+		want       []string
+	}{
+		{field: SortByEmail, want: []string{"masked-1", "masked-2", "masked-3", "masked-4"}},
+		{field: SortByEmail, descending: true, want: []string{"masked-4", "masked-3", "masked-2", "masked-1"}},
+		// trimmed and case-insensitive
+		{field: SortByDomain, want: []string{"masked-3", "masked-1", "masked-2", "masked-4"}},
+		{field: SortByDescription, want: []string{"masked-3", "masked-1", "masked-2", "masked-4"}},
+		{field: SortByState, want: []string{"masked-4", "masked-2", "masked-1", "masked-3"}},
+		// ties keep their order
+		{field: SortByCreatedBy, want: []string{"masked-2", "masked-1", "masked-3", "masked-4"}},
+		{field: SortByCreatedAt, descending: true, want: []string{"masked-4", "masked-3", "masked-2", "masked-1"}},
+		// never received a message sorts first
+		{field: SortByLastMessageAt, want: []string{"masked-3", "masked-2", "masked-4", "masked-1"}},
+		// unknown fields sort by email
+		{field: SortField("unknown"), want: []string{"masked-1", "masked-2", "masked-3", "masked-4"}},
+	}
+
+	for _, tt := range tests {
+		name := string(tt.field)
+		if tt.descending {
+			name = "-" + name
+		}
+
+		t.Run(name, func(t *testing.T) {
+			emails := append([]*MaskedEmail{}, filterTestEmails[3], filterTestEmails[1], filterTestEmails[0], filterTestEmails[2])
+			SortMaskedEmails(emails, tt.field, tt.descending)
+
+			if got := ids(emails); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+package jmaptest_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+	"github.com/dvcrn/maskedemail-cli/pkg/jmaptest"
+)
+
+func start(t *testing.T, opts ...jmaptest.Option) (*jmaptest.Server, *pkg.Client, *pkg.SessionResource) {
+	t.Helper()
+
+	srv, client := jmaptest.Start(t, opts...)
+	session, err := client.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return srv, client, session
+}
+
+// find returns the masked email of the server with the given ID.
+func find(t *testing.T, srv *jmaptest.Server, id string) *pkg.MaskedEmail {
+	t.Helper()
+
+	for _, email := range srv.MaskedEmails() {
+		if email.ID == id {
+			return email
+		}
+	}
+
+	t.Fatalf("masked email %s not found", id)
+	return nil
+}
+
+func TestCreate(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	srv, client, session := start(t, jmaptest.WithEmailDomain("fastmail.test"), jmaptest.WithClock(func() time.Time { return now }))
+
+	created, err := client.CreateMaskedEmailFromSpec(session, "", pkg.CreateSpec{
+		Domain:      "github.com",
+		Description: "GitHub",
+		EmailPrefix: "gh",
+		URL:         "https://github.com/login",
+		Enabled:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != "masked-1" || created.Email != "gh.1@fastmail.test" || created.State != pkg.MaskedEmailStateEnabled {
+		t.Errorf("unexpected created masked email: %+v", created)
+	}
+
+	got := find(t, srv, created.ID)
+	want := pkg.MaskedEmail{
+		ID:          "masked-1",
+		Email:       "gh.1@fastmail.test",
+		Domain:      "github.com",
+		Description: "GitHub",
+		URL:         "https://github.com/login",
+		State:       pkg.MaskedEmailStateEnabled,
+		CreatedBy:   jmaptest.DefaultCreatedBy,
+		CreatedAt:   now,
+	}
+	if got.ID != want.ID || got.Email != want.Email || got.Domain != want.Domain || got.Description != want.Description ||
+		got.URL != want.URL || got.State != want.State || got.CreatedBy != want.CreatedBy || !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	pending, err := client.CreateMaskedEmail(session, "", "example.com", "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := find(t, srv, pending.ID).State; state != pkg.MaskedEmailStatePending {
+		t.Errorf("got state %s, want pending", state)
+	}
+
+	if srv.State() != "2" {
+		t.Errorf("got state %s after two creates, want 2", srv.State())
+	}
+}
+
+func TestCreateInvalid(t *testing.T) {
+	srv, client, session := start(t)
+
+	_, err := client.CreateMaskedEmail(session, "", "example.com", "", "Not-Valid", true)
+
+	var setErr *pkg.SetError
+	if !errors.As(err, &setErr) || setErr.Type != "invalidProperties" || len(setErr.Properties) != 1 || setErr.Properties[0] != "emailPrefix" {
+		t.Fatalf("got error %v, want invalidProperties of emailPrefix", err)
+	}
+	if n := len(srv.MaskedEmails()); n != 0 {
+		t.Errorf("got %d masked emails, want none", n)
+	}
+}
+
+func TestCreatedBy(t *testing.T) {
+	srv, client, session := start(t, jmaptest.WithCreatedBy("1Password"))
+
+	// the server attributes masked emails to the credentials, not to the
+	// app name of the client
+	created, err := client.WithAppName("other-app").CreateMaskedEmail(session, "", "example.com", "", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if createdBy := find(t, srv, created.ID).CreatedBy; createdBy != "1Password" {
+		t.Errorf("got createdBy %q, want 1Password", createdBy)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	srv, client, session := start(t, jmaptest.WithMaskedEmails(&pkg.MaskedEmail{ID: "masked-1", Domain: "old.com"}))
+
+	_, err := client.UpdateMaskedEmail(session, "", "masked-1",
+		pkg.WithUpdateDomain("new.com"),
+		pkg.WithUpdateDescription("New"),
+		pkg.WithUpdateURL("https://new.com"),
+		pkg.WithUpdateState(pkg.MaskedEmailStateDisabled),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := find(t, srv, "masked-1")
+	if got.Domain != "new.com" || got.Description != "New" || got.URL != "https://new.com" || got.State != pkg.MaskedEmailStateDisabled {
+		t.Errorf("unexpected updated masked email: %+v", got)
+	}
+
+	_, err = client.UpdateMaskedEmail(session, "", "masked-404", pkg.WithUpdateDescription("x"))
+	var setErr *pkg.SetError
+	if !errors.As(err, &setErr) || setErr.Type != "notFound" || setErr.ID != "masked-404" {
+		t.Errorf("got error %v, want notFound for masked-404", err)
+	}
+
+	_, err = client.UpdateMaskedEmail(session, "", "masked-1", pkg.WithUpdateState(pkg.MaskedEmailStatePending))
+	if !errors.As(err, &setErr) || setErr.Type != "invalidProperties" {
+		t.Errorf("got error %v, want invalidProperties", err)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	srv, client, session := start(t)
+
+	created, err := client.CreateMaskedEmails(session, "", []pkg.CreateSpec{
+		{Domain: "a.com", Enabled: true},
+		{Domain: "b.com", EmailPrefix: "INVALID"},
+		{Domain: "c.com", URL: "https://c.com"},
+	})
+
+	var setErrs pkg.SetErrors
+	if !errors.As(err, &setErrs) || len(setErrs) != 1 || setErrs[0].Type != "invalidProperties" {
+		t.Fatalf("got error %v, want one invalidProperties error", err)
+	}
+	if len(created) != 3 || created[0] == nil || created[1] != nil || created[2] == nil {
+		t.Fatalf("got %v, want the first and last spec created", created)
+	}
+	if created[0].Domain != "a.com" || created[2].URL != "https://c.com" {
+		t.Errorf("unexpected created masked emails: %+v, %+v", created[0], created[2])
+	}
+	if n := len(srv.MaskedEmails()); n != 2 {
+		t.Errorf("got %d masked emails on the server, want 2", n)
+	}
+
+	res, err := client.UpdateMaskedEmails(session, "", map[string][]pkg.UpdateOption{
+		created[0].ID: {pkg.WithUpdateState(pkg.MaskedEmailStateDeleted)},
+		created[2].ID: {pkg.WithUpdateDescription("C")},
+		"masked-404":  {pkg.WithUpdateDescription("x")},
+	})
+
+	if !errors.As(err, &setErrs) || len(setErrs) != 1 || setErrs[0].ID != "masked-404" {
+		t.Fatalf("got error %v, want notFound for masked-404", err)
+	}
+
+	var updated []string
+	for id := range res.Updated {
+		updated = append(updated, id)
+	}
+	sort.Strings(updated)
+	if len(updated) != 2 || updated[0] != created[0].ID || updated[1] != created[2].ID {
+		t.Errorf("got updated %v, want %s and %s", updated, created[0].ID, created[2].ID)
+	}
+
+	if state := find(t, srv, created[0].ID).State; state != pkg.MaskedEmailStateDeleted {
+		t.Errorf("got state %s, want deleted", state)
+	}
+	if description := find(t, srv, created[2].ID).Description; description != "C" {
+		t.Errorf("got description %q, want C", description)
+	}
+}
+
+func TestChangesPaging(t *testing.T) {
+	srv, client, session := start(t, jmaptest.WithMaxChanges(2))
+
+	since := srv.State()
+	var specs []pkg.CreateSpec
+	for i := 0; i < 5; i++ {
+		specs = append(specs, pkg.CreateSpec{Domain: "example.com", Enabled: true})
+	}
+	created, err := client.CreateMaskedEmails(session, "", specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// updated before the client saw it, so it's only reported as created
+	if _, err := client.UpdateMaskedEmail(session, "", created[4].ID, pkg.WithUpdateState(pkg.MaskedEmailStateDeleted)); err != nil {
+		t.Fatal(err)
+	}
+
+	var pages [][]string
+	state := since
+	for {
+		changes, err := client.GetMaskedEmailChanges(session, "", state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changes.OldState != state {
+			t.Errorf("got oldState %s, want %s", changes.OldState, state)
+		}
+		if len(changes.Created) > 2 {
+			t.Errorf("got %d changes, want at most 2", len(changes.Created))
+		}
+
+		pages = append(pages, changes.Created)
+		state = changes.NewState
+		if !changes.HasMoreChanges {
+			break
+		}
+	}
+
+	if len(pages) != 3 {
+		t.Fatalf("got %d pages, want 3: %v", len(pages), pages)
+	}
+	if state != srv.State() {
+		t.Errorf("got final state %s, want %s", state, srv.State())
+	}
+
+	var all []string
+	for _, page := range pages {
+		all = append(all, page...)
+	}
+	if len(all) != 5 {
+		t.Errorf("got created %v, want the 5 created masked emails", all)
+	}
+
+	_, err = client.GetMaskedEmailChanges(session, "", "999")
+	var methodErr *pkg.MethodError
+	if !errors.As(err, &methodErr) || methodErr.Type != "cannotCalculateChanges" {
+		t.Errorf("got error %v, want cannotCalculateChanges", err)
+	}
+}
+
+func TestCacheSyncPaging(t *testing.T) {
+	srv, client, session := start(t, jmaptest.WithMaxChanges(2), jmaptest.WithMaskedEmails(
+		&pkg.MaskedEmail{ID: "masked-1"},
+		&pkg.MaskedEmail{ID: "masked-2"},
+	))
+
+	cache := &pkg.MaskedEmailCache{}
+	result, err := client.SyncMaskedEmailCache(session, "", cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Full || len(cache.Emails) != 2 || cache.State != srv.State() {
+		t.Fatalf("got %+v with %d masked emails, want a full sync of 2", result, len(cache.Emails))
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.CreateMaskedEmail(session, "", "example.com", "", "", true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.UpdateMaskedEmail(session, "", "masked-1", pkg.WithUpdateDescription("updated")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = client.SyncMaskedEmailCache(session, "", cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Full || result.Created != 3 || result.Updated != 1 {
+		t.Errorf("got %+v, want 3 created and 1 updated", result)
+	}
+	if len(cache.Emails) != 5 || cache.Emails["masked-1"].Description != "updated" || cache.State != srv.State() {
+		t.Errorf("cache not up to date: %d masked emails, state %s", len(cache.Emails), cache.State)
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure jmaptest.Failure
+		check   func(err error) bool
+	}{
+		{
+			name:    "status code",
+			failure: jmaptest.Failure{StatusCode: http.StatusServiceUnavailable},
+			check: func(err error) bool {
+				var httpErr *pkg.HTTPError
+				return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusServiceUnavailable
+			},
+		},
+		{
+			name:    "method error",
+			failure: jmaptest.Failure{Method: "MaskedEmail/set", ErrorType: "serverFail"},
+			check: func(err error) bool {
+				var methodErr *pkg.MethodError
+				return errors.As(err, &methodErr) && methodErr.Type == "serverFail" && methodErr.MethodName == "MaskedEmail/set"
+			},
+		},
+		{
+			name:    "set error",
+			failure: jmaptest.Failure{Method: "MaskedEmail/set", SetErrorType: "forbidden"},
+			check: func(err error) bool {
+				var setErr *pkg.SetError
+				return errors.As(err, &setErr) && setErr.Type == "forbidden"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, client, session := start(t)
+			srv.FailNext(tt.failure)
+
+			_, err := client.CreateMaskedEmail(session, "", "example.com", "", "", true)
+			if !tt.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if n := len(srv.MaskedEmails()); n != 0 {
+				t.Errorf("got %d masked emails after the failure, want none", n)
+			}
+
+			// the failure is only injected once
+			if _, err := client.CreateMaskedEmail(session, "", "example.com", "", "", true); err != nil {
+				t.Errorf("unexpected error after the failure: %v", err)
+			}
+		})
+	}
+}
+
+func TestFailNextMethod(t *testing.T) {
+	srv, client, session := start(t, jmaptest.WithMaskedEmails(&pkg.MaskedEmail{ID: "masked-1"}))
+	srv.FailNext(jmaptest.Failure{Method: "MaskedEmail/set", ErrorType: "serverFail"})
+
+	// calls of other methods aren't affected
+	if _, err := client.GetAllMaskedEmails(session, "", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := client.UpdateMaskedEmail(session, "", "masked-1", pkg.WithUpdateDescription("x"))
+	var methodErr *pkg.MethodError
+	if !errors.As(err, &methodErr) || methodErr.Type != "serverFail" {
+		t.Errorf("got error %v, want serverFail", err)
+	}
+}
+
+func TestWithToken(t *testing.T) {
+	_, client := jmaptest.Start(t, jmaptest.WithToken("secret"))
+	if _, err := client.Session(); err != nil {
+		t.Fatalf("unexpected error with the token: %v", err)
+	}
+
+	ts := httptest.NewServer(jmaptest.NewServer(jmaptest.WithToken("secret")))
+	defer ts.Close()
+
+	client = pkg.NewClient("wrong", "jmaptest", "", pkg.WithSessionEndpoint(ts.URL+jmaptest.SessionPath))
+	_, err := client.Session()
+
+	var httpErr *pkg.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got error %v, want 401", err)
+	}
+}
+
+func TestUnknownProperties(t *testing.T) {
+	_, client, session := start(t, jmaptest.WithMaskedEmails(&pkg.MaskedEmail{
+		ID:    "masked-1",
+		Extra: map[string]json.RawMessage{"future": json.RawMessage(`{"a":[1,2]}`)},
+	}))
+
+	hasFuture := func(email *pkg.MaskedEmail) bool {
+		return email != nil && string(email.Extra["future"]) == `{"a":[1,2]}`
+	}
+
+	emails, err := client.GetMaskedEmails(session, "", []string{"masked-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 1 {
+		t.Fatalf("got %d masked emails, want 1", len(emails))
+	}
+	if !hasFuture(emails[0]) {
+		t.Fatalf("unknown property lost by GetMaskedEmails: %+v", emails[0])
+	}
+
+	cache := &pkg.MaskedEmailCache{}
+	if _, err := client.SyncMaskedEmailCache(session, "", cache); err != nil {
+		t.Fatal(err)
+	}
+	if !hasFuture(cache.Emails["masked-1"]) {
+		t.Fatalf("unknown property lost by the full sync: %+v", cache.Emails["masked-1"])
+	}
+
+	// refetched by the incremental sync
+	if _, err := client.UpdateMaskedEmail(session, "", "masked-1", pkg.WithUpdateDescription("updated")); err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.SyncMaskedEmailCache(session, "", cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Full || result.Updated != 1 {
+		t.Fatalf("got %+v, want an incremental sync", result)
+	}
+
+	email := cache.Emails["masked-1"]
+	if email.Description != "updated" || !hasFuture(email) {
+		t.Fatalf("unknown property lost by the incremental sync: %+v", email)
+	}
+
+	// and kept when the cache is stored
+	data, err := json.Marshal(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored pkg.MaskedEmailCache
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !hasFuture(stored.Emails["masked-1"]) {
+		t.Errorf("unknown property lost by storing the cache: %s", data)
+	}
+}
@@ -0,0 +1,505 @@
+package jmaptest
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+type changeKind int
+
+const (
+	changeCreated changeKind = iota
+	changeUpdated
+	changeDestroyed
+)
+
+// change is an entry of the changelog used by MaskedEmail/changes.
+type change struct {
+	state int
+	id    string
+	kind  changeKind
+}
+
+// emailPrefixPattern is what Fastmail accepts as emailPrefix.
+var emailPrefixPattern = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+// record bumps the state for a change of the masked email with the given ID.
+func (s *Server) record(id string, kind changeKind) {
+	s.state++
+	s.changelog = append(s.changelog, change{state: s.state, id: id, kind: kind})
+}
+
+// object returns the JSON object of a masked email with the given properties,
+// or all of them if properties is nil. Extra properties are served like known
+// ones.
+func object(e *pkg.MaskedEmail, properties []string) map[string]interface{} {
+	nullable := func(s string) interface{} {
+		if s == "" {
+			return nil
+		}
+		return s
+	}
+
+	var lastMessageAt interface{}
+	if e.LastMessageAt != nil {
+		lastMessageAt = utcDate(*e.LastMessageAt)
+	}
+
+	all := map[string]interface{}{
+		"id":            e.ID,
+		"email":         e.Email,
+		"state":         e.State,
+		"forDomain":     e.Domain,
+		"description":   e.Description,
+		"url":           nullable(e.URL),
+		"createdBy":     e.CreatedBy,
+		"createdAt":     utcDate(e.CreatedAt),
+		"lastMessageAt": lastMessageAt,
+	}
+	for key, v := range e.Extra {
+		if _, ok := all[key]; !ok {
+			all[key] = v
+		}
+	}
+	if properties == nil {
+		return all
+	}
+
+	obj := map[string]interface{}{"id": e.ID}
+	for _, p := range properties {
+		if v, ok := all[p]; ok {
+			obj[p] = v
+		}
+	}
+
+	return obj
+}
+
+func (s *Server) get(args json.RawMessage) (interface{}, *methodError) {
+	var req struct {
+		IDs        *[]string `json:"ids"`
+		Properties []string  `json:"properties"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil {
+		return nil, &methodError{Type: "invalidArguments", Description: err.Error()}
+	}
+
+	ids := s.order
+	if req.IDs != nil {
+		ids = *req.IDs
+	}
+
+	list := []interface{}{}
+	notFound := []string{}
+	for _, id := range ids {
+		e, ok := s.emails[id]
+		if !ok {
+			notFound = append(notFound, id)
+			continue
+		}
+
+		list = append(list, object(e, req.Properties))
+	}
+
+	return map[string]interface{}{
+		"accountId": s.accountID,
+		"state":     s.stateString(),
+		"list":      list,
+		"notFound":  notFound,
+	}, nil
+}
+
+// setError is a JMAP SetError.
+type setError struct {
+	Type        string   `json:"type"`
+	Description string   `json:"description,omitempty"`
+	Properties  []string `json:"properties,omitempty"`
+}
+
+func invalidProperties(description string, properties ...string) *setError {
+	return &setError{Type: "invalidProperties", Description: description, Properties: properties}
+}
+
+// readOnlyProperties can't be set by clients.
+var readOnlyProperties = []string{"id", "email", "createdAt", "createdBy", "lastMessageAt"}
+
+// decodeProperties decodes the string properties of a create or update,
+// rejecting read-only and non-string ones. null is decoded as an empty string.
+func decodeProperties(raw json.RawMessage) (map[string]string, *setError) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, invalidProperties(err.Error())
+	}
+
+	props := make(map[string]string, len(obj))
+	for key, v := range obj {
+		for _, ro := range readOnlyProperties {
+			if key == ro {
+				return nil, invalidProperties(key+" is read-only", key)
+			}
+		}
+
+		var s *string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return nil, invalidProperties(key+" must be a string", key)
+		}
+		props[key] = ""
+		if s != nil {
+			props[key] = *s
+		}
+	}
+
+	return props, nil
+}
+
+func (s *Server) set(args json.RawMessage) (interface{}, *methodError) {
+	var req struct {
+		IfInState *string                    `json:"ifInState"`
+		Create    map[string]json.RawMessage `json:"create"`
+		Update    map[string]json.RawMessage `json:"update"`
+		Destroy   []string                   `json:"destroy"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil {
+		return nil, &methodError{Type: "invalidArguments", Description: err.Error()}
+	}
+
+	if req.IfInState != nil && *req.IfInState != s.stateString() {
+		return nil, &methodError{Type: "stateMismatch"}
+	}
+
+	var injected *setError
+	if f, ok := s.takeFailure("MaskedEmail/set", func(f Failure) bool { return f.SetErrorType != "" }); ok {
+		injected = &setError{Type: f.SetErrorType, Description: "injected failure"}
+	}
+
+	oldState := s.stateString()
+	created := map[string]interface{}{}
+	notCreated := map[string]*setError{}
+	updated := map[string]interface{}{}
+	notUpdated := map[string]*setError{}
+	destroyed := []string{}
+	notDestroyed := map[string]*setError{}
+
+	for _, creationID := range sortedKeys(req.Create) {
+		if injected != nil {
+			notCreated[creationID] = injected
+			continue
+		}
+
+		e, setErr := s.create(creationID, req.Create[creationID])
+		if setErr != nil {
+			notCreated[creationID] = setErr
+			continue
+		}
+
+		created[creationID] = object(e, nil)
+	}
+
+	for _, id := range sortedKeys(req.Update) {
+		if injected != nil {
+			notUpdated[id] = injected
+			continue
+		}
+
+		if setErr := s.update(id, req.Update[id]); setErr != nil {
+			notUpdated[id] = setErr
+			continue
+		}
+
+		updated[id] = nil
+	}
+
+	for _, id := range req.Destroy {
+		if injected != nil {
+			notDestroyed[id] = injected
+			continue
+		}
+
+		if _, ok := s.emails[id]; !ok {
+			notDestroyed[id] = &setError{Type: "notFound"}
+			continue
+		}
+
+		delete(s.emails, id)
+		for i, orderID := range s.order {
+			if orderID == id {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+		s.record(id, changeDestroyed)
+		destroyed = append(destroyed, id)
+	}
+
+	return map[string]interface{}{
+		"accountId":    s.accountID,
+		"oldState":     oldState,
+		"newState":     s.stateString(),
+		"created":      created,
+		"updated":      updated,
+		"destroyed":    destroyed,
+		"notCreated":   notCreated,
+		"notUpdated":   notUpdated,
+		"notDestroyed": notDestroyed,
+	}, nil
+}
+
+// create adds a masked email, created by the app set with WithCreatedBy.
+func (s *Server) create(creationID string, raw json.RawMessage) (*pkg.MaskedEmail, *setError) {
+	props, setErr := decodeProperties(raw)
+	if setErr != nil {
+		return nil, setErr
+	}
+
+	state := pkg.MaskedEmailState(props["state"])
+	switch state {
+	case "":
+		state = pkg.MaskedEmailStatePending
+	case pkg.MaskedEmailStateEnabled, pkg.MaskedEmailStateDisabled, pkg.MaskedEmailStatePending:
+	default:
+		return nil, invalidProperties("invalid state "+strconv.Quote(string(state)), "state")
+	}
+
+	prefix := props["emailPrefix"]
+	if prefix != "" && !emailPrefixPattern.MatchString(prefix) {
+		return nil, invalidProperties("emailPrefix must be 1-64 characters a-z, 0-9 or _", "emailPrefix")
+	}
+	if prefix == "" {
+		prefix = "masked"
+	}
+
+	e := &pkg.MaskedEmail{
+		ID:          s.newID(),
+		State:       state,
+		Domain:      props["forDomain"],
+		Description: props["description"],
+		URL:         props["url"],
+		CreatedBy:   s.createdBy,
+		CreatedAt:   s.timestamp(),
+	}
+	e.Email = s.newAddress(prefix)
+
+	s.emails[e.ID] = e
+	s.order = append(s.order, e.ID)
+	s.record(e.ID, changeCreated)
+
+	return e, nil
+}
+
+func (s *Server) update(id string, raw json.RawMessage) *setError {
+	e, ok := s.emails[id]
+	if !ok {
+		return &setError{Type: "notFound"}
+	}
+
+	props, setErr := decodeProperties(raw)
+	if setErr != nil {
+		return setErr
+	}
+
+	if state, ok := props["state"]; ok && pkg.MaskedEmailState(state) != e.State {
+		switch pkg.MaskedEmailState(state) {
+		case pkg.MaskedEmailStateEnabled, pkg.MaskedEmailStateDisabled, pkg.MaskedEmailStateDeleted:
+		case pkg.MaskedEmailStatePending:
+			return invalidProperties("masked emails can't become pending", "state")
+		default:
+			return invalidProperties("invalid state "+strconv.Quote(state), "state")
+		}
+	}
+
+	updated := *e
+	for key, v := range props {
+		switch key {
+		case "state":
+			updated.State = pkg.MaskedEmailState(v)
+		case "forDomain":
+			updated.Domain = v
+		case "description":
+			updated.Description = v
+		case "url":
+			updated.URL = v
+		case "emailPrefix":
+			return invalidProperties("emailPrefix can only be set on creation", key)
+		}
+	}
+
+	*e = updated
+	s.record(id, changeUpdated)
+
+	return nil
+}
+
+func (s *Server) changes(args json.RawMessage) (interface{}, *methodError) {
+	var req struct {
+		SinceState string `json:"sinceState"`
+		MaxChanges int    `json:"maxChanges"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil {
+		return nil, &methodError{Type: "invalidArguments", Description: err.Error()}
+	}
+
+	since, err := strconv.Atoi(req.SinceState)
+	if err != nil || since < 0 || since > s.state {
+		return nil, &methodError{Type: "cannotCalculateChanges", Description: "unknown state " + strconv.Quote(req.SinceState)}
+	}
+
+	maxChanges := req.MaxChanges
+	if s.maxChanges > 0 && (maxChanges <= 0 || maxChanges > s.maxChanges) {
+		maxChanges = s.maxChanges
+	}
+
+	// the kind of change of each ID since the state, in order of first change
+	kinds := map[string]changeKind{}
+	var ids []string
+	newState := s.state
+	for _, c := range s.changelog {
+		if c.state <= since {
+			continue
+		}
+
+		prev, seen := kinds[c.id]
+		if !seen {
+			if maxChanges > 0 && len(ids) == maxChanges {
+				newState = c.state - 1
+				break
+			}
+			ids = append(ids, c.id)
+			kinds[c.id] = c.kind
+			continue
+		}
+
+		switch {
+		case prev == changeCreated && c.kind == changeDestroyed:
+			// never seen by the client
+			kinds[c.id] = -1
+		case prev == changeCreated || prev == -1:
+		default:
+			kinds[c.id] = c.kind
+		}
+	}
+
+	created, updated, destroyed := []string{}, []string{}, []string{}
+	for _, id := range ids {
+		switch kinds[id] {
+		case changeCreated:
+			created = append(created, id)
+		case changeUpdated:
+			updated = append(updated, id)
+		case changeDestroyed:
+			destroyed = append(destroyed, id)
+		}
+	}
+
+	return map[string]interface{}{
+		"accountId":      s.accountID,
+		"oldState":       req.SinceState,
+		"newState":       strconv.Itoa(newState),
+		"hasMoreChanges": newState != s.state,
+		"created":        created,
+		"updated":        updated,
+		"destroyed":      destroyed,
+	}, nil
+}
+
+// querySortProperties are the properties MaskedEmail/query can sort by.
+var querySortProperties = map[string]func(e *pkg.MaskedEmail) string{
+	"id":        func(e *pkg.MaskedEmail) string { return e.ID },
+	"email":     func(e *pkg.MaskedEmail) string { return e.Email },
+	"forDomain": func(e *pkg.MaskedEmail) string { return e.Domain },
+	"createdAt": func(e *pkg.MaskedEmail) string { return utcDate(e.CreatedAt) },
+	"lastMessageAt": func(e *pkg.MaskedEmail) string {
+		if e.LastMessageAt == nil {
+			return ""
+		}
+		return utcDate(*e.LastMessageAt)
+	},
+}
+
+func (s *Server) query(args json.RawMessage) (interface{}, *methodError) {
+	var req struct {
+		Filter map[string]string `json:"filter"`
+		Sort   []struct {
+			Property    string `json:"property"`
+			IsAscending *bool  `json:"isAscending"`
+		} `json:"sort"`
+		Position int  `json:"position"`
+		Limit    *int `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil {
+		return nil, &methodError{Type: "invalidArguments", Description: err.Error()}
+	}
+
+	for key := range req.Filter {
+		switch key {
+		case "state", "forDomain", "createdBy":
+		default:
+			return nil, &methodError{Type: "unsupportedFilter", Description: key}
+		}
+	}
+	for _, c := range req.Sort {
+		if _, ok := querySortProperties[c.Property]; !ok {
+			return nil, &methodError{Type: "unsupportedSort", Description: c.Property}
+		}
+	}
+	if req.Position < 0 || (req.Limit != nil && *req.Limit < 0) {
+		return nil, &methodError{Type: "invalidArguments", Description: "position and limit must not be negative"}
+	}
+
+	var matches []*pkg.MaskedEmail
+	for _, id := range s.order {
+		e := s.emails[id]
+		if state, ok := req.Filter["state"]; ok && string(e.State) != state {
+			continue
+		}
+		if domain, ok := req.Filter["forDomain"]; ok && !strings.EqualFold(e.Domain, domain) {
+			continue
+		}
+		if createdBy, ok := req.Filter["createdBy"]; ok && e.CreatedBy != createdBy {
+			continue
+		}
+		matches = append(matches, e)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		for _, c := range req.Sort {
+			value := querySortProperties[c.Property]
+			a, b := value(matches[i]), value(matches[j])
+			if a == b {
+				continue
+			}
+			if c.IsAscending != nil && !*c.IsAscending {
+				return a > b
+			}
+			return a < b
+		}
+		return false
+	})
+
+	total := len(matches)
+	if req.Position > total {
+		req.Position = total
+	}
+	matches = matches[req.Position:]
+	if req.Limit != nil && *req.Limit < len(matches) {
+		matches = matches[:*req.Limit]
+	}
+
+	ids := make([]string, len(matches))
+	for i, e := range matches {
+		ids[i] = e.ID
+	}
+
+	return map[string]interface{}{
+		"accountId":           s.accountID,
+		"queryState":          s.stateString(),
+		"canCalculateChanges": false,
+		"position":            req.Position,
+		"total":               total,
+		"ids":                 ids,
+	}, nil
+}
@@ -0,0 +1,517 @@
+// Package jmaptest provides an in-memory fake of the Fastmail JMAP API for
+// masked emails, to test code using pkg.Client without network access.
+//
+// The fake implements the session resource and the MaskedEmail/get, /set,
+// /changes and /query methods. Failures and latency can be injected to test
+// error handling:
+//
+//	srv, client := jmaptest.Start(t, jmaptest.WithMaskedEmails(&pkg.MaskedEmail{
+//		Email:  "123@example.com",
+//		Domain: "github.com",
+//	}))
+//	srv.FailNext(jmaptest.Failure{Method: "MaskedEmail/set", ErrorType: "serverFail"})
+package jmaptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+const (
+	// SessionPath is the path of the session resource.
+	SessionPath = "/jmap/session"
+	// APIPath is the path JMAP API requests are sent to.
+	APIPath = "/jmap/api/"
+
+	// DefaultAccountID is the ID of the account unless set with
+	// WithAccountID.
+	DefaultAccountID = "u1234"
+	// DefaultEmailDomain is the domain of created masked emails unless set
+	// with WithEmailDomain.
+	DefaultEmailDomain = "example.com"
+	// DefaultCreatedBy is the createdBy of created masked emails unless set
+	// with WithCreatedBy. It's the app name of the client returned by Start.
+	DefaultCreatedBy = "jmaptest"
+)
+
+// Failure is an injected failure, see Server.FailNext. Exactly one of
+// StatusCode, ErrorType and SetErrorType should be set.
+type Failure struct {
+	// Method limits the failure to requests calling this method, eg.
+	// "MaskedEmail/set". Empty matches any API request.
+	Method string
+	// StatusCode fails the whole HTTP request with this status, eg. 503.
+	StatusCode int
+	// ErrorType answers the method call with a method-level error of this
+	// type, eg. "serverFail".
+	ErrorType string
+	// SetErrorType rejects every create, update and destroy of a
+	// MaskedEmail/set call with a SetError of this type, eg. "forbidden".
+	SetErrorType string
+}
+
+// Server is the fake JMAP server. It's an http.Handler serving the session
+// resource at SessionPath and the API at APIPath, and is safe for concurrent
+// use.
+type Server struct {
+	accountID   string
+	token       string
+	emailDomain string
+	createdBy   string
+	latency     time.Duration
+	failureRate float64
+	maxChanges  int
+	now         func() time.Time
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	emails    map[string]*pkg.MaskedEmail
+	order     []string
+	nextID    int
+	state     int
+	changelog []change
+	failures  []Failure
+}
+
+// Option configures a Server.
+type Option func(s *Server)
+
+// WithAccountID sets the ID of the single masked email account.
+func WithAccountID(accountID string) Option {
+	return func(s *Server) {
+		s.accountID = accountID
+	}
+}
+
+// WithToken makes the server reject requests without this bearer token. By
+// default any credentials are accepted.
+func WithToken(token string) Option {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// WithEmailDomain sets the domain of the addresses of created masked emails.
+func WithEmailDomain(domain string) Option {
+	return func(s *Server) {
+		s.emailDomain = domain
+	}
+}
+
+// WithCreatedBy sets the createdBy of masked emails created through the API.
+// Like Fastmail, the server doesn't take it from the request.
+func WithCreatedBy(appName string) Option {
+	return func(s *Server) {
+		s.createdBy = appName
+	}
+}
+
+// WithLatency delays every response by d.
+func WithLatency(d time.Duration) Option {
+	return func(s *Server) {
+		s.latency = d
+	}
+}
+
+// WithFailureRate fails the given fraction (0 to 1) of API requests at random
+// with 503 Service Unavailable.
+func WithFailureRate(rate float64) Option {
+	return func(s *Server) {
+		s.failureRate = rate
+	}
+}
+
+// WithMaxChanges limits the number of changes returned by a single
+// MaskedEmail/changes call, which makes clients page with hasMoreChanges.
+func WithMaxChanges(n int) Option {
+	return func(s *Server) {
+		s.maxChanges = n
+	}
+}
+
+// WithClock sets the clock used for the createdAt of new masked emails.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) {
+		s.now = now
+	}
+}
+
+// WithMaskedEmails adds existing masked emails to the server. Missing IDs,
+// addresses, states and creation times are filled in. Their Extra properties
+// are served too, like from a server with newer properties. The masked emails
+// are copied.
+func WithMaskedEmails(emails ...*pkg.MaskedEmail) Option {
+	return func(s *Server) {
+		for _, email := range emails {
+			e := *email
+			if e.ID == "" {
+				e.ID = s.newID()
+			}
+			if e.Email == "" {
+				e.Email = s.newAddress("masked")
+			}
+			if e.State == "" {
+				e.State = pkg.MaskedEmailStateEnabled
+			}
+			if e.CreatedAt.IsZero() {
+				e.CreatedAt = s.timestamp()
+			}
+
+			if _, ok := s.emails[e.ID]; !ok {
+				s.order = append(s.order, e.ID)
+			}
+			s.emails[e.ID] = &e
+		}
+	}
+}
+
+// NewServer creates a fake server, to be served with http.Server or
+// httptest.NewServer.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		accountID:   DefaultAccountID,
+		emailDomain: DefaultEmailDomain,
+		createdBy:   DefaultCreatedBy,
+		now:         time.Now,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		emails:      map[string]*pkg.MaskedEmail{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Start serves a new fake server on a local test server, closed when the
+// test ends, and returns it with a client using its session endpoint.
+func Start(tb testing.TB, opts ...Option) (*Server, *pkg.Client) {
+	tb.Helper()
+
+	s := NewServer(opts...)
+	ts := httptest.NewServer(s)
+	tb.Cleanup(ts.Close)
+
+	token := s.token
+	if token == "" {
+		token = "jmaptest"
+	}
+
+	client := pkg.NewClient(token, DefaultCreatedBy, "", pkg.WithSessionEndpoint(ts.URL+SessionPath))
+	return s, client
+}
+
+// AccountID returns the ID of the masked email account.
+func (s *Server) AccountID() string {
+	return s.accountID
+}
+
+// FailNext queues a failure for the next request or method call it matches.
+// Queued failures are used up in order.
+func (s *Server) FailNext(f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, f)
+}
+
+// MaskedEmails returns copies of all masked emails in creation order,
+// including deleted ones.
+func (s *Server) MaskedEmails() []*pkg.MaskedEmail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	emails := make([]*pkg.MaskedEmail, 0, len(s.order))
+	for _, id := range s.order {
+		e := *s.emails[id]
+		emails = append(emails, &e)
+	}
+
+	return emails
+}
+
+// State returns the current state string of the masked email data.
+func (s *Server) State() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.stateString()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.latency > 0 {
+		select {
+		case <-time.After(s.latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="jmaptest"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == SessionPath || r.URL.Path == "/.well-known/jmap":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveSession(w, r)
+	case r.URL.Path == APIPath || r.URL.Path == strings.TrimSuffix(APIPath, "/"):
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveAPI(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveSession(w http.ResponseWriter, r *http.Request) {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	capabilities := map[string]interface{}{
+		"urn:ietf:params:jmap:core":  map[string]interface{}{},
+		pkg.MaskedEmailCapabilityURI: map[string]interface{}{},
+	}
+
+	s.mu.Lock()
+	state := s.stateString()
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"capabilities": capabilities,
+		"accounts": map[string]interface{}{
+			s.accountID: map[string]interface{}{
+				"name":       "jmaptest@" + s.emailDomain,
+				"isPersonal": true,
+				"isReadOnly": false,
+				"accountCapabilities": map[string]interface{}{
+					pkg.MaskedEmailCapabilityURI: map[string]interface{}{},
+				},
+			},
+		},
+		"primaryAccounts": map[string]string{
+			pkg.MaskedEmailCapabilityURI: s.accountID,
+		},
+		"username": "jmaptest@" + s.emailDomain,
+		"apiUrl":   scheme + "://" + r.Host + APIPath,
+		"state":    state,
+	})
+}
+
+// apiRequest is a JMAP request with the method calls still encoded.
+type apiRequest struct {
+	Using       []string            `json:"using"`
+	MethodCalls [][]json.RawMessage `json:"methodCalls"`
+}
+
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
+	var req apiRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProblem(w, http.StatusBadRequest, "notJSON", err.Error())
+		return
+	}
+
+	usesMaskedEmail := false
+	for _, capability := range req.Using {
+		usesMaskedEmail = usesMaskedEmail || capability == pkg.MaskedEmailCapabilityURI
+	}
+	if !usesMaskedEmail {
+		writeProblem(w, http.StatusBadRequest, "unknownCapability", "the request doesn't use "+pkg.MaskedEmailCapabilityURI)
+		return
+	}
+
+	type call struct {
+		name   string
+		args   json.RawMessage
+		callID string
+	}
+	calls := make([]call, len(req.MethodCalls))
+	for i, mc := range req.MethodCalls {
+		if len(mc) != 3 {
+			writeProblem(w, http.StatusBadRequest, "notRequest", "method calls must have 3 elements")
+			return
+		}
+		if err := json.Unmarshal(mc[0], &calls[i].name); err != nil {
+			writeProblem(w, http.StatusBadRequest, "notRequest", "invalid method name")
+			return
+		}
+		if err := json.Unmarshal(mc[2], &calls[i].callID); err != nil {
+			writeProblem(w, http.StatusBadRequest, "notRequest", "invalid method call ID")
+			return
+		}
+		calls[i].args = mc[1]
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.failureRate > 0 && s.rand.Float64() < s.failureRate {
+		http.Error(w, "injected failure", http.StatusServiceUnavailable)
+		return
+	}
+
+	for _, c := range calls {
+		if f, ok := s.takeFailure(c.name, func(f Failure) bool { return f.StatusCode != 0 }); ok {
+			http.Error(w, "injected failure", f.StatusCode)
+			return
+		}
+	}
+
+	responses := make([][]interface{}, 0, len(calls))
+	for _, c := range calls {
+		res, err := s.call(c.name, c.args)
+		if err != nil {
+			responses = append(responses, []interface{}{"error", err, c.callID})
+			continue
+		}
+
+		responses = append(responses, []interface{}{c.name, res, c.callID})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"methodResponses": responses,
+		"sessionState":    s.stateString(),
+	})
+}
+
+// takeFailure removes and returns the first queued failure for the method
+// that matches.
+func (s *Server) takeFailure(method string, match func(f Failure) bool) (Failure, bool) {
+	for i, f := range s.failures {
+		if (f.Method == "" || f.Method == method) && match(f) {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			return f, true
+		}
+	}
+
+	return Failure{}, false
+}
+
+// methodError is a JMAP method-level error.
+type methodError struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+func (e *methodError) Error() string {
+	return e.Type + ": " + e.Description
+}
+
+// call handles a single method call. s.mu must be held.
+func (s *Server) call(name string, args json.RawMessage) (interface{}, *methodError) {
+	if f, ok := s.takeFailure(name, func(f Failure) bool { return f.ErrorType != "" }); ok {
+		return nil, &methodError{Type: f.ErrorType, Description: "injected failure"}
+	}
+
+	var base struct {
+		AccountID string `json:"accountId"`
+	}
+	if err := json.Unmarshal(args, &base); err != nil {
+		return nil, &methodError{Type: "invalidArguments", Description: err.Error()}
+	}
+	if base.AccountID != s.accountID {
+		return nil, &methodError{Type: "accountNotFound", Description: fmt.Sprintf("unknown account %q", base.AccountID)}
+	}
+
+	switch name {
+	case "MaskedEmail/get":
+		return s.get(args)
+	case "MaskedEmail/set":
+		return s.set(args)
+	case "MaskedEmail/changes":
+		return s.changes(args)
+	case "MaskedEmail/query":
+		return s.query(args)
+	default:
+		return nil, &methodError{Type: "unknownMethod", Description: name}
+	}
+}
+
+func (s *Server) stateString() string {
+	return fmt.Sprint(s.state)
+}
+
+// timestamp returns the current time with the precision of a JMAP UTCDate.
+func (s *Server) timestamp() time.Time {
+	return s.now().UTC().Truncate(time.Second)
+}
+
+// utcDate formats t as a JMAP UTCDate.
+func utcDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// newID returns an unused masked email ID.
+func (s *Server) newID() string {
+	for {
+		s.nextID++
+		id := fmt.Sprintf("masked-%d", s.nextID)
+		if _, ok := s.emails[id]; !ok {
+			return id
+		}
+	}
+}
+
+// newAddress returns an unused address for a new masked email.
+func (s *Server) newAddress(prefix string) string {
+	for n := s.nextID; ; n++ {
+		email := fmt.Sprintf("%s.%d@%s", prefix, n, s.emailDomain)
+
+		taken := false
+		for _, e := range s.emails {
+			taken = taken || strings.EqualFold(e.Email, email)
+		}
+		if !taken {
+			return email
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in order, so set calls are processed
+// deterministically.
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeProblem writes a JMAP request-level error.
+func writeProblem(w http.ResponseWriter, status int, errType string, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"type":   "urn:ietf:params:jmap:error:" + errType,
+		"status": status,
+		"detail": detail,
+	})
+}
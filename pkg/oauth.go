@@ -0,0 +1,461 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOAuthIssuer is the Fastmail OAuth authorization server, whose
+	// endpoints are discovered with DiscoverOAuth.
+	DefaultOAuthIssuer = "https://api.fastmail.com"
+
+	// JMAPCoreCapabilityURI is the capability URI of the JMAP core, also used
+	// as OAuth scope.
+	JMAPCoreCapabilityURI = "urn:ietf:params:jmap:core"
+
+	oauthMetadataPath = "/.well-known/oauth-authorization-server"
+	deviceCodeGrant   = "urn:ietf:params:oauth:grant-type:device_code"
+
+	// tokenExpiryDelta refreshes tokens shortly before they expire, to account
+	// for clock skew and request latency.
+	tokenExpiryDelta = 30 * time.Second
+)
+
+// DefaultOAuthScopes are requested if OAuthConfig.Scopes is empty.
+var DefaultOAuthScopes = []string{JMAPCoreCapabilityURI, MaskedEmailCapabilityURI}
+
+// OAuthConfig describes an OAuth client registered with the authorization
+// server. Only the fields needed for the flows in use must be set, eg.
+// ClientID and TokenEndpoint for refreshing a token.
+type OAuthConfig struct {
+	ClientID                    string
+	AuthorizationEndpoint       string
+	TokenEndpoint               string
+	DeviceAuthorizationEndpoint string
+	Scopes                      []string
+	// HTTPClient is used for requests to the authorization server,
+	// http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// oauthServerMetadata is the authorization server metadata document.
+//
+// https://www.rfc-editor.org/rfc/rfc8414#section-2
+type oauthServerMetadata struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+}
+
+// DiscoverOAuth fetches the metadata of the authorization server at issuer
+// and returns a config for the client with its endpoints.
+func DiscoverOAuth(ctx context.Context, httpClient *http.Client, issuer string, clientID string) (*OAuthConfig, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	metadataURL := strings.TrimSuffix(issuer, "/") + oauthMetadataPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, newHTTPError(res.StatusCode, res.Status, body)
+	}
+
+	var metadata oauthServerMetadata
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return nil, fmt.Errorf("decoding oauth metadata: %w", err)
+	}
+
+	if metadata.TokenEndpoint == "" {
+		return nil, fmt.Errorf("oauth metadata of %s has no token endpoint", issuer)
+	}
+
+	return &OAuthConfig{
+		ClientID:                    clientID,
+		AuthorizationEndpoint:       metadata.AuthorizationEndpoint,
+		TokenEndpoint:               metadata.TokenEndpoint,
+		DeviceAuthorizationEndpoint: metadata.DeviceAuthorizationEndpoint,
+		HTTPClient:                  httpClient,
+	}, nil
+}
+
+// OAuthToken is an access token with the refresh token to renew it.
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type,omitempty"`
+	// Expiry is when the access token expires, zero if unknown.
+	Expiry time.Time `json:"expiry"`
+}
+
+// Expired returns true if the access token expired or is about to.
+func (t *OAuthToken) Expired() bool {
+	return !t.Expiry.IsZero() && time.Now().Add(tokenExpiryDelta).After(t.Expiry)
+}
+
+// tokenResponse is a successful response of the token endpoint.
+//
+// https://www.rfc-editor.org/rfc/rfc6749#section-5.1
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// PKCE holds the code verifier of an authorization code flow and its S256
+// challenge.
+//
+// https://www.rfc-editor.org/rfc/rfc7636
+type PKCE struct {
+	Verifier  string
+	Challenge string
+}
+
+// NewPKCE generates a random code verifier.
+func NewPKCE() (*PKCE, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return &PKCE{
+		Verifier:  verifier,
+		Challenge: base64.RawURLEncoding.EncodeToString(sum[:]),
+	}, nil
+}
+
+// NewOAuthState generates a random state parameter for AuthCodeURL.
+func NewOAuthState() (string, error) {
+	return randomString(16)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (c *OAuthConfig) scope() string {
+	if len(c.Scopes) == 0 {
+		return strings.Join(DefaultOAuthScopes, " ")
+	}
+
+	return strings.Join(c.Scopes, " ")
+}
+
+// AuthCodeURL returns the URL to send the user to for authorizing the client.
+// The authorization server redirects back to redirectURI with the code and
+// state.
+func (c *OAuthConfig) AuthCodeURL(state string, redirectURI string, pkce *PKCE) string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {c.scope()},
+		"state":                 {state},
+		"code_challenge":        {pkce.Challenge},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(c.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return c.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// Exchange trades the authorization code for a token.
+func (c *OAuthConfig) Exchange(ctx context.Context, code string, redirectURI string, pkce *PKCE) (*OAuthToken, error) {
+	return c.requestToken(ctx, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {c.ClientID},
+		"code_verifier": {pkce.Verifier},
+	})
+}
+
+// RefreshToken renews an access token. The refresh token is kept if the
+// server doesn't rotate it.
+func (c *OAuthConfig) RefreshToken(ctx context.Context, refreshToken string) (*OAuthToken, error) {
+	token, err := c.requestToken(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {c.ClientID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if token.RefreshToken == "" {
+		token.RefreshToken = refreshToken
+	}
+
+	return token, nil
+}
+
+// DeviceAuth is the response of the device authorization endpoint. The user
+// has to visit VerificationURI and enter UserCode while the client polls for
+// the token.
+//
+// https://www.rfc-editor.org/rfc/rfc8628#section-3.2
+type DeviceAuth struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	// ExpiresIn and Interval are in seconds.
+	ExpiresIn int64 `json:"expires_in"`
+	Interval  int64 `json:"interval,omitempty"`
+}
+
+// DeviceAuth starts a device authorization flow.
+func (c *OAuthConfig) DeviceAuth(ctx context.Context) (*DeviceAuth, error) {
+	if c.DeviceAuthorizationEndpoint == "" {
+		return nil, errors.New("authorization server doesn't support the device flow")
+	}
+
+	body, err := c.postForm(ctx, c.DeviceAuthorizationEndpoint, url.Values{
+		"client_id": {c.ClientID},
+		"scope":     {c.scope()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var auth DeviceAuth
+	if err := json.Unmarshal(body, &auth); err != nil {
+		return nil, fmt.Errorf("decoding device authorization: %w", err)
+	}
+
+	if auth.DeviceCode == "" || auth.UserCode == "" {
+		return nil, errors.New("device authorization response is missing the device or user code")
+	}
+
+	return &auth, nil
+}
+
+// PollDeviceToken polls the token endpoint until the user approved or denied
+// the device authorization, it expired or ctx is done.
+func (c *OAuthConfig) PollDeviceToken(ctx context.Context, auth *DeviceAuth) (*OAuthToken, error) {
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	if auth.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(auth.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, errors.New("device authorization expired")
+			}
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		token, err := c.requestToken(ctx, url.Values{
+			"grant_type":  {deviceCodeGrant},
+			"device_code": {auth.DeviceCode},
+			"client_id":   {c.ClientID},
+		})
+
+		var oauthErr *OAuthError
+		switch {
+		case errors.As(err, &oauthErr) && oauthErr.Code == "authorization_pending":
+			continue
+		case errors.As(err, &oauthErr) && oauthErr.Code == "slow_down":
+			interval += 5 * time.Second
+			continue
+		case err != nil:
+			return nil, err
+		}
+
+		return token, nil
+	}
+}
+
+// requestToken posts a grant to the token endpoint.
+func (c *OAuthConfig) requestToken(ctx context.Context, params url.Values) (*OAuthToken, error) {
+	body, err := c.postForm(ctx, c.TokenEndpoint, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var res tokenResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("decoding token response: %w", err)
+	}
+
+	if res.AccessToken == "" {
+		return nil, errors.New("token response has no access token")
+	}
+
+	token := &OAuthToken{
+		AccessToken:  res.AccessToken,
+		RefreshToken: res.RefreshToken,
+		TokenType:    res.TokenType,
+	}
+	if res.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
+
+// postForm posts form encoded params and returns the body of a successful
+// response. Error responses are returned as *OAuthError where possible.
+func (c *OAuthConfig) postForm(ctx context.Context, endpoint string, params url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		oauthErr := &OAuthError{StatusCode: res.StatusCode}
+		if err := json.Unmarshal(body, oauthErr); err == nil && oauthErr.Code != "" {
+			return nil, oauthErr
+		}
+
+		return nil, newHTTPError(res.StatusCode, res.Status, body)
+	}
+
+	return body, nil
+}
+
+// OAuthAuthenticator authorizes requests with an OAuth access token, which is
+// refreshed when it expires or the API rejects it.
+type OAuthAuthenticator struct {
+	config *OAuthConfig
+
+	// OnRefresh is called with the new token after each refresh, eg. to
+	// persist it. An error fails the request that triggered the refresh.
+	OnRefresh func(token *OAuthToken) error
+
+	mu    sync.Mutex
+	token OAuthToken
+}
+
+var (
+	_ Authenticator = &OAuthAuthenticator{}
+	_ Refresher     = &OAuthAuthenticator{}
+)
+
+// NewOAuthAuthenticator creates an authenticator using the token. Refreshing
+// requires config to have the ClientID and TokenEndpoint set.
+func NewOAuthAuthenticator(config *OAuthConfig, token *OAuthToken) *OAuthAuthenticator {
+	return &OAuthAuthenticator{config: config, token: *token}
+}
+
+// Token returns a copy of the current token.
+func (a *OAuthAuthenticator) Token() *OAuthToken {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	token := a.token
+	return &token
+}
+
+func (a *OAuthAuthenticator) Authorize(req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token.Expired() && a.token.RefreshToken != "" {
+		if err := a.refresh(req.Context()); err != nil {
+			return fmt.Errorf("refreshing expired token: %w", err)
+		}
+	}
+
+	req.Header.Set("Authorization", "Bearer "+a.token.AccessToken)
+	return nil
+}
+
+// Refresh renews the token, unless it was already renewed since rejected was
+// authorized. Concurrent requests rejected with the same token thus only
+// refresh once, which matters for servers rotating refresh tokens.
+func (a *OAuthAuthenticator) Refresh(rejected *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if rejected.Header.Get("Authorization") != "Bearer "+a.token.AccessToken {
+		return nil
+	}
+
+	return a.refresh(rejected.Context())
+}
+
+// refresh renews the token, a.mu must be held.
+func (a *OAuthAuthenticator) refresh(ctx context.Context) error {
+	if a.token.RefreshToken == "" {
+		return errors.New("no refresh token, login again")
+	}
+
+	token, err := a.config.RefreshToken(ctx, a.token.RefreshToken)
+	if err != nil {
+		return err
+	}
+	a.token = *token
+
+	if a.OnRefresh != nil {
+		return a.OnRefresh(token)
+	}
+
+	return nil
+}
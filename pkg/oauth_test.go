@@ -0,0 +1,437 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAuthServer is an OAuth authorization server for one client, which
+// also serves a JMAP session endpoint accepting only its current access token.
+// Refresh tokens are rotated and can only be used once.
+type fakeAuthServer struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	challenges    map[string]string // authorization code -> code challenge
+	accessToken   string
+	refreshToken  string
+	issued        int
+	refreshes     int
+	devicePolls   int
+	pendingPolls  int
+	rejectRefresh bool
+}
+
+func newFakeAuthServer(t *testing.T) *fakeAuthServer {
+	s := &fakeAuthServer{challenges: map[string]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(oauthMetadataPath, func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]string{
+			"issuer":                        s.URL,
+			"authorization_endpoint":        s.URL + "/authorize",
+			"token_endpoint":                s.URL + "/token",
+			"device_authorization_endpoint": s.URL + "/device",
+		})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("client_id") != "test-client" {
+			writeTestJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"device_code":      "device-1",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": s.URL + "/activate",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jmap/session", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		ok := s.accessToken != "" && r.Header.Get("Authorization") == "Bearer "+s.accessToken
+		s.mu.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		writeTestJSON(w, http.StatusOK, map[string]string{"apiUrl": s.URL + "/jmap/api/"})
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// authorize records an authorization code for the PKCE challenge, like the
+// authorization endpoint after the user approved.
+func (s *fakeAuthServer) authorize(code string, challenge string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.challenges[code] = challenge
+}
+
+// issue returns a new token pair, s.mu must be held.
+func (s *fakeAuthServer) issue(w http.ResponseWriter) {
+	s.issued++
+	s.accessToken = fmt.Sprintf("access-%d", s.issued)
+	s.refreshToken = fmt.Sprintf("refresh-%d", s.issued)
+
+	writeTestJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  s.accessToken,
+		"refresh_token": s.refreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+func (s *fakeAuthServer) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invalidGrant := func() {
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	}
+
+	if r.PostFormValue("client_id") != "test-client" {
+		writeTestJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		challenge, ok := s.challenges[r.PostFormValue("code")]
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			invalidGrant()
+			return
+		}
+		delete(s.challenges, r.PostFormValue("code"))
+		s.issue(w)
+
+	case "refresh_token":
+		s.refreshes++
+		if s.rejectRefresh || r.PostFormValue("refresh_token") != s.refreshToken {
+			invalidGrant()
+			return
+		}
+		s.issue(w)
+
+	case deviceCodeGrant:
+		s.devicePolls++
+		if r.PostFormValue("device_code") != "device-1" {
+			invalidGrant()
+			return
+		}
+		if s.devicePolls <= s.pendingPolls {
+			writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "authorization_pending"})
+			return
+		}
+		s.issue(w)
+
+	default:
+		writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+	}
+}
+
+// revoke invalidates the current access token, as if it expired early.
+func (s *fakeAuthServer) revoke() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accessToken = "revoked"
+}
+
+func (s *fakeAuthServer) config(t *testing.T) *OAuthConfig {
+	t.Helper()
+
+	config, err := DiscoverOAuth(context.Background(), s.Client(), s.URL, "test-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+func TestDiscoverOAuth(t *testing.T) {
+	s := newFakeAuthServer(t)
+	config := s.config(t)
+
+	if config.TokenEndpoint != s.URL+"/token" || config.DeviceAuthorizationEndpoint != s.URL+"/device" || config.ClientID != "test-client" {
+		t.Errorf("unexpected config: %+v", config)
+	}
+
+	if _, err := DiscoverOAuth(context.Background(), s.Client(), s.URL+"/missing", "test-client"); err == nil {
+		t.Error("expected an error for a missing metadata document")
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	s := newFakeAuthServer(t)
+	config := s.config(t)
+
+	pkce, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkce.Verifier) < 43 || len(pkce.Verifier) > 128 {
+		t.Errorf("code verifier must be 43-128 characters, got %d", len(pkce.Verifier))
+	}
+	sum := sha256.Sum256([]byte(pkce.Verifier))
+	if pkce.Challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		t.Error("challenge is not the S256 of the verifier")
+	}
+
+	other, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Verifier == pkce.Verifier {
+		t.Error("code verifiers must be random")
+	}
+
+	authURL, err := url.Parse(config.AuthCodeURL("state-1", "http://127.0.0.1:1234/callback", pkce))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	for key, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             "test-client",
+		"redirect_uri":          "http://127.0.0.1:1234/callback",
+		"scope":                 JMAPCoreCapabilityURI + " " + MaskedEmailCapabilityURI,
+		"state":                 "state-1",
+		"code_challenge":        pkce.Challenge,
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Get(key); got != want {
+			t.Errorf("auth url %s = %q, want %q", key, got, want)
+		}
+	}
+
+	s.authorize("code-1", pkce.Challenge)
+
+	_, err = config.Exchange(context.Background(), "code-1", "http://127.0.0.1:1234/callback", other)
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Fatalf("exchanging with the wrong verifier: got %v, want invalid_grant", err)
+	}
+
+	s.authorize("code-1", pkce.Challenge)
+	token, err := config.Exchange(context.Background(), "code-1", "http://127.0.0.1:1234/callback", pkce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" || token.RefreshToken != "refresh-1" || token.Expired() {
+		t.Errorf("unexpected token: %+v", token)
+	}
+}
+
+func TestDeviceFlow(t *testing.T) {
+	s := newFakeAuthServer(t)
+	s.pendingPolls = 1
+	config := s.config(t)
+
+	auth, err := config.DeviceAuth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.UserCode != "ABCD-EFGH" || auth.VerificationURI != s.URL+"/activate" {
+		t.Errorf("unexpected device authorization: %+v", auth)
+	}
+
+	token, err := config.PollDeviceToken(context.Background(), auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-1" {
+		t.Errorf("unexpected token: %+v", token)
+	}
+	if s.devicePolls != 2 {
+		t.Errorf("got %d polls, want 2", s.devicePolls)
+	}
+
+	config.DeviceAuthorizationEndpoint = ""
+	if _, err := config.DeviceAuth(context.Background()); err == nil {
+		t.Error("expected an error without a device authorization endpoint")
+	}
+}
+
+func TestDeviceFlowCanceled(t *testing.T) {
+	s := newFakeAuthServer(t)
+	s.pendingPolls = 100
+	config := s.config(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+
+	_, err := config.PollDeviceToken(ctx, &DeviceAuth{DeviceCode: "device-1", Interval: 1})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestRefreshOn401(t *testing.T) {
+	s := newFakeAuthServer(t)
+	config := s.config(t)
+
+	pkce, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.authorize("code-1", pkce.Challenge)
+	token, err := config.Exchange(context.Background(), "code-1", "http://127.0.0.1/callback", pkce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := NewOAuthAuthenticator(config, token)
+	var persisted []string
+	auth.OnRefresh = func(token *OAuthToken) error {
+		persisted = append(persisted, token.RefreshToken)
+		return nil
+	}
+
+	client := NewClient("", "test", "", WithAuthenticator(auth), WithSessionEndpoint(s.URL+"/jmap/session"), WithHTTPClient(s.Client()))
+	if _, err := client.Session(); err != nil {
+		t.Fatal(err)
+	}
+	if s.refreshes != 0 {
+		t.Errorf("refreshed a valid token %d times", s.refreshes)
+	}
+
+	s.revoke()
+	if _, err := client.Session(); err != nil {
+		t.Fatal(err)
+	}
+	if s.refreshes != 1 || auth.Token().AccessToken != "access-2" {
+		t.Errorf("expected one refresh to access-2, got %d refreshes and %+v", s.refreshes, auth.Token())
+	}
+	if len(persisted) != 1 || persisted[0] != "refresh-2" {
+		t.Errorf("rotated refresh token not persisted: %v", persisted)
+	}
+}
+
+func TestRefreshOn401Concurrent(t *testing.T) {
+	s := newFakeAuthServer(t)
+	config := s.config(t)
+
+	pkce, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.authorize("code-1", pkce.Challenge)
+	token, err := config.Exchange(context.Background(), "code-1", "http://127.0.0.1/callback", pkce)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := NewOAuthAuthenticator(config, token)
+	client := NewClient("", "test", "", WithAuthenticator(auth), WithSessionEndpoint(s.URL+"/jmap/session"), WithHTTPClient(s.Client()))
+
+	s.revoke()
+
+	// authorize all requests with the revoked token before any refresh
+	const n = 10
+	reqs := make([]*http.Request, n)
+	for i := range reqs {
+		reqs[i], err = http.NewRequest(http.MethodGet, s.URL+"/jmap/session", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := range reqs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			res, err := client.doRequest(reqs[i])
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				errs[i] = errors.New(res.Status)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("request %d: %v", i, err)
+		}
+	}
+	if s.refreshes != 1 {
+		t.Errorf("got %d refreshes, want 1", s.refreshes)
+	}
+}
+
+func TestRefreshFailureKeepsHTTPError(t *testing.T) {
+	s := newFakeAuthServer(t)
+	s.rejectRefresh = true
+	config := s.config(t)
+
+	auth := NewOAuthAuthenticator(config, &OAuthToken{AccessToken: "stale", RefreshToken: "refresh-0"})
+	client := NewClient("", "test", "", WithAuthenticator(auth), WithSessionEndpoint(s.URL+"/jmap/session"), WithHTTPClient(s.Client()))
+
+	_, err := client.Session()
+
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("got %v, want the 401 as *HTTPError", err)
+	}
+	if !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("the refresh error is missing from %q", err)
+	}
+}
+
+func TestOAuthTokenJSON(t *testing.T) {
+	var token OAuthToken
+	if err := json.Unmarshal([]byte(`{"access_token":"a"}`), &token); err != nil {
+		t.Fatal(err)
+	}
+	if !token.Expiry.IsZero() || token.Expired() {
+		t.Errorf("a token without expiry must not expire: %+v", token)
+	}
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded OAuthToken
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Expiry.IsZero() || decoded.Expired() {
+		t.Errorf("round trip of %s gave an expiring token: %+v", data, decoded)
+	}
+}
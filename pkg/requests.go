@@ -6,8 +6,12 @@ type MaskedEmailState string
 
 const (
 	MaskedEmailStateEnabled  MaskedEmailState = "enabled"
-	MaskedEmailStateDisabled                  = "disabled"
-	MaskedEmailStateDeleted                   = "deleted"
+	MaskedEmailStateDisabled MaskedEmailState = "disabled"
+	MaskedEmailStateDeleted  MaskedEmailState = "deleted"
+	// MaskedEmailStatePending is the state of masked emails created without
+	// being enabled. They need to be confirmed (enabled) or receive a message
+	// before Fastmail expires them.
+	MaskedEmailStatePending MaskedEmailState = "pending"
 )
 
 type APIRequest struct {
@@ -60,6 +64,7 @@ type CreatePayload struct {
 	State       string `json:"state,omitempty"`
 	Description string `json:"description"`
 	EmailPrefix string `json:"emailPrefix"`
+	URL         string `json:"url,omitempty"`
 }
 
 type MethodCallCreate struct {
@@ -71,6 +76,8 @@ type UpdatePayload struct {
 	State       string `json:"state,omitempty"`
 	Domain      string `json:"forDomain,omitempty"`
 	Description string `json:"description,omitempty"`
+	// URL is a json string, or null to clear the url.
+	URL json.RawMessage `json:"url,omitempty"`
 }
 
 type UpdateOption func(c *UpdatePayload)
@@ -99,6 +106,29 @@ func WithUpdateDescription(desc string) UpdateOption {
 	}
 }
 
+// WithUpdateURL sets the url of the masked email, eg. the login page of the
+// site it's used for. An empty url clears it.
+func WithUpdateURL(url string) UpdateOption {
+	return func(f *UpdatePayload) {
+		if url == "" {
+			f.URL = json.RawMessage("null")
+			return
+		}
+
+		f.URL, _ = json.Marshal(url)
+	}
+}
+
+// NewCreatePayload creates the payload for a single maskedemail to create.
+func NewCreatePayload(domain string, state string, description string, emailPrefix string) CreatePayload {
+	return CreatePayload{
+		Domain:      domain,
+		State:       state,
+		Description: description,
+		EmailPrefix: emailPrefix,
+	}
+}
+
 // NewMethodCallCreate creates a new method call to create a new maskedemail.
 // accID is the users account ID.
 // appName is the name to identify the app that created the maskedemail.
@@ -106,16 +136,17 @@ func WithUpdateDescription(desc string) UpdateOption {
 // description is a description of the masked email
 // emailPrefix is the prefix for the masked email
 func NewMethodCallCreate(accID, appName, domain string, state string, description string, emailPrefix string) MethodCallCreate {
+	return NewMethodCallCreateBatch(accID, map[string]CreatePayload{
+		appName: NewCreatePayload(domain, state, description, emailPrefix),
+	})
+}
+
+// NewMethodCallCreateBatch creates a new method call to create many
+// maskedemails at once, keyed by creation ID.
+func NewMethodCallCreateBatch(accID string, payloads map[string]CreatePayload) MethodCallCreate {
 	mesp := MethodCallCreate{}
 	mesp.AccountID = accID
-	mesp.Create = map[string]CreatePayload{
-		appName: {
-			Domain:      domain,
-			State:       state,
-			Description: description,
-			EmailPrefix: emailPrefix,
-		},
-	}
+	mesp.Create = payloads
 
 	return mesp
 }
@@ -127,16 +158,25 @@ type MethodCallUpdate struct {
 
 // NewMethodCallUpdate creates a new method call to update a maskedemail.
 func NewMethodCallUpdate(accID, alias string, updateOpts ...UpdateOption) MethodCallUpdate {
+	return NewMethodCallUpdateBatch(accID, map[string][]UpdateOption{
+		alias: updateOpts,
+	})
+}
+
+// NewMethodCallUpdateBatch creates a new method call to update many
+// maskedemails at once, keyed by maskedemail ID.
+func NewMethodCallUpdateBatch(accID string, updates map[string][]UpdateOption) MethodCallUpdate {
 	mesp := MethodCallUpdate{}
 	mesp.AccountID = accID
+	mesp.Update = make(map[string]UpdatePayload, len(updates))
 
-	payload := &UpdatePayload{}
-	for _, opt := range updateOpts {
-		opt(payload)
-	}
+	for alias, updateOpts := range updates {
+		payload := &UpdatePayload{}
+		for _, opt := range updateOpts {
+			opt(payload)
+		}
 
-	mesp.Update = map[string]UpdatePayload{
-		alias: *payload,
+		mesp.Update[alias] = *payload
 	}
 
 	return mesp
@@ -190,3 +230,56 @@ func NewMethodCallGetAll(accID string) MethodCallGetAll {
 
 	return mesp
 }
+
+// MaskedEmailProperties lists the properties of a MaskedEmail object known to
+// this package. Other properties are kept in MaskedEmail.Extra.
+var MaskedEmailProperties = []string{
+	"id",
+	"email",
+	"state",
+	"forDomain",
+	"description",
+	"url",
+	"createdBy",
+	"createdAt",
+	"lastMessageAt",
+}
+
+// MethodCallGet is a method call to get specific maskedemails by ID.
+type MethodCallGet struct {
+	AccountID  string   `json:"accountId,omitempty"`
+	IDs        []string `json:"ids"`
+	Properties []string `json:"properties,omitempty"`
+}
+
+// NewMethodCallGet creates a new method call to get the maskedemails with the
+// given IDs. If properties is empty, all properties are returned.
+func NewMethodCallGet(accID string, ids []string, properties []string) MethodCallGet {
+	mesp := MethodCallGet{}
+	mesp.AccountID = accID
+	mesp.IDs = ids
+	mesp.Properties = properties
+
+	return mesp
+}
+
+// MethodCallChanges is a method call to get the IDs of maskedemails that
+// changed since the given state.
+//
+// https://jmap.io/spec-core.html#changes
+type MethodCallChanges struct {
+	AccountID  string `json:"accountId,omitempty"`
+	SinceState string `json:"sinceState"`
+	MaxChanges int    `json:"maxChanges,omitempty"`
+}
+
+// NewMethodCallChanges creates a new method call to get the changes since the
+// given state. A maxChanges of 0 leaves the limit to the server.
+func NewMethodCallChanges(accID string, sinceState string, maxChanges int) MethodCallChanges {
+	mesp := MethodCallChanges{}
+	mesp.AccountID = accID
+	mesp.SinceState = sinceState
+	mesp.MaxChanges = maxChanges
+
+	return mesp
+}
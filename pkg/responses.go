@@ -1,35 +1,78 @@
 package pkg
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"time"
 )
 
+// MethodResponse is a single method response, sent by the server as
+// [name, arguments, callID].
 type MethodResponse struct {
 	MethodName string
-	Payload    interface{}
-	Payload2   string
+	// Payload is the raw arguments object, see Decode.
+	Payload json.RawMessage
+	// Payload2 is the ID of the method call the response belongs to.
+	Payload2 string
+}
+
+// Decode decodes the arguments of the response into out.
+func (mr *MethodResponse) Decode(out interface{}) error {
+	return json.Unmarshal(mr.Payload, out)
 }
 
 type APIResponse struct {
-	LatestClientVersion   string          `json:"latestClientVersion,omitempty"`
-	MethodResponses       [][]interface{} `json:"methodResponses,omitempty"`
-	MethodResponsesParsed []MethodResponse
-	SessionState          string `json:"sessionState,omitempty"`
+	LatestClientVersion   string              `json:"latestClientVersion,omitempty"`
+	MethodResponses       [][]json.RawMessage `json:"methodResponses,omitempty"`
+	MethodResponsesParsed []MethodResponse    `json:"-"`
+	SessionState          string              `json:"sessionState,omitempty"`
 }
 
+// UnmarshalJSON parses the response, checking that every method response is
+// a [name, arguments, callID] triple.
 func (gr *APIResponse) UnmarshalJSON(b []byte) error {
 	type apiResponse2 APIResponse
-	if err := json.Unmarshal(b, (*apiResponse2)(gr)); err != nil {
+	raw := struct {
+		*apiResponse2
+		MethodResponses json.RawMessage `json:"methodResponses"`
+	}{apiResponse2: (*apiResponse2)(gr)}
+	if err := json.Unmarshal(b, &raw); err != nil {
 		return err
 	}
 
-	responses := []MethodResponse{}
-	for _, res := range gr.MethodResponses {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw.MethodResponses, &list); len(raw.MethodResponses) > 0 && err != nil {
+		return errors.New("methodResponses is not an array")
+	}
+
+	gr.MethodResponses = make([][]json.RawMessage, len(list))
+	responses := make([]MethodResponse, 0, len(list))
+	for i, item := range list {
+		var res []json.RawMessage
+		if err := json.Unmarshal(item, &res); err != nil {
+			return fmt.Errorf("method response %d is not an array", i)
+		}
+		gr.MethodResponses[i] = res
+
+		if len(res) != 3 {
+			return fmt.Errorf("method response %d: expected 3 elements, got %d", i, len(res))
+		}
+
 		r := MethodResponse{}
-		r.MethodName = res[0].(string)
+		if err := json.Unmarshal(res[0], &r.MethodName); err != nil {
+			return fmt.Errorf("method response %d: method name is not a string", i)
+		}
+		if err := json.Unmarshal(res[2], &r.Payload2); err != nil {
+			return fmt.Errorf("method response %d (%s): method call ID is not a string", i, r.MethodName)
+		}
+
+		trimmed := bytes.TrimSpace(res[1])
+		if len(trimmed) == 0 || trimmed[0] != '{' {
+			return fmt.Errorf("method response %d (%s): arguments are not an object", i, r.MethodName)
+		}
 		r.Payload = res[1]
-		r.Payload2 = res[2].(string)
 
 		responses = append(responses, r)
 	}
@@ -38,40 +81,245 @@ func (gr *APIResponse) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// Response returns the first response to the method call with the given ID.
+// The server may answer calls in any order, so responses must not be matched
+// by position.
+func (gr *APIResponse) Response(callID string) (*MethodResponse, error) {
+	for i := range gr.MethodResponsesParsed {
+		if gr.MethodResponsesParsed[i].Payload2 == callID {
+			return &gr.MethodResponsesParsed[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("no response to method call %q", callID)
+}
+
 type MaskedEmail struct {
-	CreatedAt     string `mapstructure:"createdAt" json:"createdAt"`
-	CreatedBy     string `mapstructure:"createdBy" json:"createdBy"`
-	Description   string `mapstructure:"description" json:"description"`
-	Email         string `mapstructure:"email" json:"email"`
-	ID            string `mapstructure:"id" json:"id"`
-	LastMessageAt string `mapstructure:"lastMessageAt" json:"lastMessageAt"`
-	State         string `mapstructure:"state" json:"state"`
-	URL           string `mapstructure:"url" json:"url"`
-	Domain        string `mapstructure:"forDomain" json:"forDomain"`
+	CreatedAt   time.Time `json:"createdAt"`
+	CreatedBy   string    `json:"createdBy"`
+	Description string    `json:"description"`
+	Email       string    `json:"email"`
+	ID          string    `json:"id"`
+	// LastMessageAt is nil if the masked email never received a message.
+	LastMessageAt *time.Time       `json:"lastMessageAt"`
+	State         MaskedEmailState `json:"state"`
+	URL           string           `json:"url"`
+	Domain        string           `json:"forDomain"`
+	// Extra holds the properties not known to this package, as sent by the
+	// server. They are kept when encoding to json, so newer properties
+	// survive the cache, export and json output.
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// maskedEmailJSON is the json encoding of a MaskedEmail. Times are RFC 3339
+// strings in UTC, unset times are empty strings.
+type maskedEmailJSON struct {
+	CreatedAt     string           `json:"createdAt"`
+	CreatedBy     string           `json:"createdBy"`
+	Description   string           `json:"description"`
+	Email         string           `json:"email"`
+	ID            string           `json:"id"`
+	LastMessageAt string           `json:"lastMessageAt"`
+	State         MaskedEmailState `json:"state"`
+	URL           string           `json:"url"`
+	Domain        string           `json:"forDomain"`
+}
+
+// MarshalJSON encodes times as RFC 3339 strings in UTC like the JMAP API, but
+// unset times as empty strings.
+func (e MaskedEmail) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(maskedEmailJSON{
+		CreatedAt:     formatUTCDate(e.CreatedAt),
+		CreatedBy:     e.CreatedBy,
+		Description:   e.Description,
+		Email:         e.Email,
+		ID:            e.ID,
+		LastMessageAt: formatUTCDate(timeOrZero(e.LastMessageAt)),
+		State:         e.State,
+		URL:           e.URL,
+		Domain:        e.Domain,
+	})
+	if err != nil || len(e.Extra) == 0 {
+		return b, err
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	for key, v := range e.Extra {
+		if _, ok := obj[key]; !ok {
+			obj[key] = v
+		}
+	}
+
+	return json.Marshal(obj)
+}
+
+// UnmarshalJSON decodes a masked email from the JMAP API, or as encoded by
+// MarshalJSON. Times may be null or empty.
+func (e *MaskedEmail) UnmarshalJSON(b []byte) error {
+	var v struct {
+		maskedEmailJSON
+		URL *string `json:"url"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	var extra map[string]json.RawMessage
+	if err := json.Unmarshal(b, &extra); err != nil {
+		return err
+	}
+	for _, key := range MaskedEmailProperties {
+		delete(extra, key)
+	}
+	if len(extra) == 0 {
+		extra = nil
+	}
+
+	createdAt, err := parseUTCDate(v.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("createdAt: %w", err)
+	}
+	lastMessageAt, err := parseUTCDate(v.LastMessageAt)
+	if err != nil {
+		return fmt.Errorf("lastMessageAt: %w", err)
+	}
+
+	*e = MaskedEmail{
+		CreatedAt:   createdAt,
+		CreatedBy:   v.CreatedBy,
+		Description: v.Description,
+		Email:       v.Email,
+		ID:          v.ID,
+		State:       v.State,
+		Domain:      v.Domain,
+		Extra:       extra,
+	}
+	if !lastMessageAt.IsZero() {
+		e.LastMessageAt = &lastMessageAt
+	}
+	if v.URL != nil {
+		e.URL = *v.URL
+	}
+
+	return nil
+}
+
+// parseUTCDate parses a JMAP UTCDate into UTC, also accepting other offsets,
+// and returns the zero time for an empty string.
+func parseUTCDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return t.UTC(), nil
+}
+
+func formatUTCDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return *t
 }
 
 type MethodResponseMaskedEmailSet struct {
-	AccountID string                 `mapstructure:"accountId"`
-	Created   map[string]MaskedEmail `mapstructure:"created"`
-	Updated   map[string]interface{} `mapstructure:"updated"`
-	Destroyed []interface{}          `mapstructure:"destroyed"`
-	NewState  interface{}            `mapstructure:"newState"`
-	OldState  interface{}            `mapstructure:"oldState"`
+	AccountID    string                 `json:"accountId"`
+	Created      map[string]MaskedEmail `json:"created"`
+	Updated      map[string]interface{} `json:"updated"`
+	Destroyed    []interface{}          `json:"destroyed"`
+	NotCreated   map[string]SetError    `json:"notCreated"`
+	NotUpdated   map[string]SetError    `json:"notUpdated"`
+	NotDestroyed map[string]SetError    `json:"notDestroyed"`
+	NewState     interface{}            `json:"newState"`
+	OldState     interface{}            `json:"oldState"`
 }
 
+// GetCreatedItem returns the created masked email. If the server rejected the
+// creation, the corresponding *SetError is returned.
 func (cr *MethodResponseMaskedEmailSet) GetCreatedItem() (MaskedEmail, error) {
 	for _, item := range cr.Created {
 		return item, nil
 	}
 
+	for id := range cr.NotCreated {
+		return MaskedEmail{}, cr.NotCreatedError(id)
+	}
+
 	return MaskedEmail{}, errors.New("no items returned")
 }
 
+// NotCreatedError returns the *SetError for the given creation ID, or nil if
+// it wasn't rejected.
+func (cr *MethodResponseMaskedEmailSet) NotCreatedError(id string) error {
+	return setErrorFor(cr.NotCreated, id)
+}
+
+// NotUpdatedError returns the *SetError for the given masked email ID, or nil
+// if its update wasn't rejected.
+func (cr *MethodResponseMaskedEmailSet) NotUpdatedError(id string) error {
+	return setErrorFor(cr.NotUpdated, id)
+}
+
+// NotDestroyedError returns the *SetError for the given masked email ID, or
+// nil if its destruction wasn't rejected.
+func (cr *MethodResponseMaskedEmailSet) NotDestroyedError(id string) error {
+	return setErrorFor(cr.NotDestroyed, id)
+}
+
+// setErrorOrMissing returns the *SetError for the given ID, or a generic one
+// if the server didn't mention the ID at all.
+func setErrorOrMissing(errs map[string]SetError, id string, missing string) *SetError {
+	if setErr, ok := errs[id]; ok {
+		setErr.ID = id
+		return &setErr
+	}
+
+	return &SetError{ID: id, Type: missing}
+}
+
+func setErrorFor(errs map[string]SetError, id string) error {
+	setErr, ok := errs[id]
+	if !ok {
+		return nil
+	}
+
+	setErr.ID = id
+	return &setErr
+}
+
 type MethodResponseGetAll struct {
-	AccountID string         `mapstructure:"accountId"`
-	NotFound  []interface{}  `mapstructure:"notFound"`
-	State     string         `mapstructure:"state"`
-	List      []*MaskedEmail `mapstructure:"list"`
+	AccountID string         `json:"accountId"`
+	NotFound  []interface{}  `json:"notFound"`
+	State     string         `json:"state"`
+	List      []*MaskedEmail `json:"list"`
+}
+
+// MethodResponseChanges is the response of MaskedEmail/changes. If
+// HasMoreChanges is set, further changes must be fetched since NewState.
+type MethodResponseChanges struct {
+	AccountID      string   `json:"accountId"`
+	OldState       string   `json:"oldState"`
+	NewState       string   `json:"newState"`
+	HasMoreChanges bool     `json:"hasMoreChanges"`
+	Created        []string `json:"created"`
+	Updated        []string `json:"updated"`
+	Destroyed      []string `json:"destroyed"`
 }
 
 // Account is a collection of data in the JMAP API.
@@ -0,0 +1,553 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// golden compares got with the golden file at path, or writes it with -update.
+func golden(t *testing.T, path string, got []byte) {
+	t.Helper()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file: %v (run go test -update to create it)", err)
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s changed, run go test -update if that's intended\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestRequestGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		call MethodCall
+	}{
+		{
+			name: "create",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallCreate("u1234", "maskedemail-cli", "github.com", "enabled", "GitHub", "gh"), "0"},
+		},
+		{
+			name: "create_pending",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallCreate("u1234", "maskedemail-cli", "example.com", "", "", ""), "0"},
+		},
+		{
+			name: "create_batch",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallCreateBatch("u1234", map[string]CreatePayload{
+				"app-1": NewCreatePayload("b.com", "enabled", "B", ""),
+				"app-0": NewCreatePayload("a.com", "", "A", "shop"),
+			}), "0"},
+		},
+		{
+			name: "create_url",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallCreateBatch("u1234", map[string]CreatePayload{
+				"maskedemail-cli": CreateSpec{Domain: "github.com", URL: "https://github.com/login", Enabled: true}.payload(),
+			}), "0"},
+		},
+		{
+			name: "update_state",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallUpdate("u1234", "masked-1", WithUpdateState(MaskedEmailStateDisabled)), "0"},
+		},
+		{
+			name: "update_fields",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallUpdate("u1234", "masked-1",
+				WithUpdateDomain("example.com"),
+				WithUpdateDescription("Example"),
+				WithUpdateState(MaskedEmailStateEnabled),
+				WithUpdateURL("https://example.com/login"),
+			), "0"},
+		},
+		{
+			// clearing a field sends a single space, an empty string would be
+			// dropped by omitempty
+			name: "update_clear_fields",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallUpdate("u1234", "masked-1", WithUpdateDomain(""), WithUpdateDescription("")), "0"},
+		},
+		{
+			// the url is cleared with null
+			name: "update_clear_url",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallUpdate("u1234", "masked-1", WithUpdateURL("")), "0"},
+		},
+		{
+			name: "update_no_options",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallUpdate("u1234", "masked-1"), "0"},
+		},
+		{
+			name: "update_batch",
+			call: MethodCall{"MaskedEmail/set", NewMethodCallUpdateBatch("u1234", map[string][]UpdateOption{
+				"masked-2": {WithUpdateState(MaskedEmailStateDeleted)},
+				"masked-1": {WithUpdateDescription("one")},
+			}), "0"},
+		},
+		{
+			name: "get_all",
+			call: MethodCall{"MaskedEmail/get", NewMethodCallGetAll("u1234"), "0"},
+		},
+		{
+			name: "get_ids",
+			call: MethodCall{"MaskedEmail/get", NewMethodCallGet("u1234", []string{"masked-1", "masked-2"}, MaskedEmailProperties), "0"},
+		},
+		{
+			name: "get_ids_all_properties",
+			call: MethodCall{"MaskedEmail/get", NewMethodCallGet("u1234", []string{"masked-1"}, nil), "0"},
+		},
+		{
+			name: "changes",
+			call: MethodCall{"MaskedEmail/changes", NewMethodCallChanges("u1234", "42", 0), "0"},
+		},
+		{
+			name: "changes_max",
+			call: MethodCall{"MaskedEmail/changes", NewMethodCallChanges("u1234", "42", 100), "1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &APIRequest{
+				Using:       []string{"urn:ietf:params:jmap:core", MaskedEmailCapabilityURI},
+				MethodCalls: []MethodCall{tt.call},
+			}
+
+			got, err := json.MarshalIndent(req, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden(t, filepath.Join("testdata", "requests", tt.name+".json"), append(got, '\n'))
+		})
+	}
+}
+
+// decodedResponse is the golden output of a parsed method response.
+type decodedResponse struct {
+	MethodName string
+	CallID     string
+	Decoded    interface{} `json:",omitempty"`
+	Error      string      `json:",omitempty"`
+}
+
+// decodeResponses decodes every method response of the body into the type
+// the client uses for it.
+func decodeResponses(body []byte) ([]decodedResponse, error) {
+	var res APIResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+
+	decoded := []decodedResponse{}
+	for _, mr := range res.MethodResponsesParsed {
+		var out interface{}
+		switch mr.MethodName {
+		case "MaskedEmail/get":
+			out = &MethodResponseGetAll{}
+		case "MaskedEmail/set":
+			out = &MethodResponseMaskedEmailSet{}
+		case "MaskedEmail/changes":
+			out = &MethodResponseChanges{}
+		default:
+			out = &map[string]interface{}{}
+		}
+
+		d := decodedResponse{MethodName: mr.MethodName, CallID: mr.Payload2}
+		if err := decodeMethodResponse(&res, MethodCall{MethodName: mr.MethodName, Payload2: mr.Payload2}, out); err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Decoded = out
+		}
+
+		decoded = append(decoded, d)
+	}
+
+	return decoded, nil
+}
+
+func TestResponseGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "responses", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("no response test data")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			body, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []byte
+			decoded, err := decodeResponses(body)
+			if err != nil {
+				got = []byte("error: " + err.Error())
+			} else if got, err = json.MarshalIndent(decoded, "", "  "); err != nil {
+				t.Fatal(err)
+			}
+
+			golden(t, strings.TrimSuffix(input, ".json")+".golden", append(got, '\n'))
+		})
+	}
+}
+
+func TestDecodeMethodResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{
+			name: "match by call ID",
+			body: `{"methodResponses":[["Core/echo",{},"other"],["MaskedEmail/get",{"state":"7"},"0"]]}`,
+		},
+		{
+			name:    "missing call ID",
+			body:    `{"methodResponses":[["MaskedEmail/get",{"state":"7"},"1"]]}`,
+			wantErr: `MaskedEmail/get: no response to method call "0"`,
+		},
+		{
+			name:    "no responses",
+			body:    `{"methodResponses":[]}`,
+			wantErr: `MaskedEmail/get: no response to method call "0"`,
+		},
+		{
+			name:    "unexpected method",
+			body:    `{"methodResponses":[["MaskedEmail/set",{},"0"]]}`,
+			wantErr: `MaskedEmail/get: unexpected MaskedEmail/set response to method call "0"`,
+		},
+		{
+			name:    "method error",
+			body:    `{"methodResponses":[["error",{"type":"serverFail","description":"oops"},"0"]]}`,
+			wantErr: "MaskedEmail/get: serverFail: oops",
+		},
+		{
+			name:    "invalid payload",
+			body:    `{"methodResponses":[["MaskedEmail/get",{"state":7},"0"]]}`,
+			wantErr: "MaskedEmail/get: decoding response: json: cannot unmarshal number into Go struct field MethodResponseGetAll.state of type string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res APIResponse
+			if err := json.Unmarshal([]byte(tt.body), &res); err != nil {
+				t.Fatal(err)
+			}
+
+			var out MethodResponseGetAll
+			err := decodeMethodResponse(&res, MethodCall{MethodName: "MaskedEmail/get", Payload2: "0"}, &out)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if out.State != "7" {
+					t.Errorf("decoded the wrong response: %+v", out)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("got error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaskedEmailKeepsUnknownProperties(t *testing.T) {
+	in := `{"createdAt":"2021-09-29T23:02:05Z","createdBy":"","description":"","email":"a@b.c","id":"masked-1",` +
+		`"lastMessageAt":"","state":"enabled","url":"","forDomain":"","future":{"a":[1,2]},"futureNull":null}`
+
+	var email MaskedEmail
+	if err := json.Unmarshal([]byte(in), &email); err != nil {
+		t.Fatal(err)
+	}
+	if len(email.Extra) != 2 || string(email.Extra["future"]) != `{"a":[1,2]}` || string(email.Extra["futureNull"]) != "null" {
+		t.Errorf("unknown properties not kept: %v", email.Extra)
+	}
+
+	out, err := json.Marshal(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(in), &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip changed the masked email\ngot:  %s\nwant: %s", out, in)
+	}
+}
+
+func TestMaskedEmailTimesJSON(t *testing.T) {
+	created := time.Date(2021, 9, 29, 23, 2, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// createdAt and lastMessageAt are the raw json values, or left out
+		// if empty
+		createdAt     string
+		lastMessageAt string
+
+		wantCreatedAt     time.Time
+		wantLastMessageAt *time.Time
+		// wantJSON are the encoded createdAt and lastMessageAt
+		wantJSON string
+		wantErr  string
+	}{
+		{
+			name:          "utc",
+			createdAt:     `"2021-09-29T23:02:05Z"`,
+			lastMessageAt: `"2021-09-29T23:02:05Z"`,
+			wantCreatedAt: created, wantLastMessageAt: &created,
+			wantJSON: `"2021-09-29T23:02:05Z" "2021-09-29T23:02:05Z"`,
+		},
+		{
+			name:          "lastMessageAt null",
+			createdAt:     `"2021-09-29T23:02:05Z"`,
+			lastMessageAt: `null`,
+			wantCreatedAt: created,
+			wantJSON:      `"2021-09-29T23:02:05Z" ""`,
+		},
+		{
+			name:          "lastMessageAt empty",
+			createdAt:     `"2021-09-29T23:02:05Z"`,
+			lastMessageAt: `""`,
+			wantCreatedAt: created,
+			wantJSON:      `"2021-09-29T23:02:05Z" ""`,
+		},
+		{
+			name:          "lastMessageAt missing",
+			createdAt:     `"2021-09-29T23:02:05Z"`,
+			wantCreatedAt: created,
+			wantJSON:      `"2021-09-29T23:02:05Z" ""`,
+		},
+		{
+			name:          "createdAt empty",
+			createdAt:     `""`,
+			lastMessageAt: `null`,
+			wantJSON:      `"" ""`,
+		},
+		{
+			name:              "createdAt null",
+			createdAt:         `null`,
+			lastMessageAt:     `"2021-09-29T23:02:05Z"`,
+			wantLastMessageAt: &created,
+			wantJSON:          `"" "2021-09-29T23:02:05Z"`,
+		},
+		{
+			name:      "createdAt zero time",
+			createdAt: `"0001-01-01T00:00:00Z"`,
+			wantJSON:  `"" ""`,
+		},
+		{
+			name:              "fractional seconds",
+			createdAt:         `"2021-09-29T23:02:05.123456789Z"`,
+			lastMessageAt:     `"2021-09-29T23:02:05.5Z"`,
+			wantCreatedAt:     created.Add(123456789),
+			wantLastMessageAt: timePtr(created.Add(500 * time.Millisecond)),
+			wantJSON:          `"2021-09-29T23:02:05.123456789Z" "2021-09-29T23:02:05.5Z"`,
+		},
+		{
+			name:              "offsets",
+			createdAt:         `"2021-09-30T01:02:05+02:00"`,
+			lastMessageAt:     `"2021-09-29T21:32:05.25-01:30"`,
+			wantCreatedAt:     created,
+			wantLastMessageAt: timePtr(created.Add(250 * time.Millisecond)),
+			wantJSON:          `"2021-09-29T23:02:05Z" "2021-09-29T23:02:05.25Z"`,
+		},
+		{
+			name:      "invalid createdAt",
+			createdAt: `"2021-09-29 23:02:05"`,
+			wantErr:   `createdAt: parsing time "2021-09-29 23:02:05" as "2006-01-02T15:04:05Z07:00": cannot parse " 23:02:05" as "T"`,
+		},
+		{
+			name:          "invalid lastMessageAt",
+			createdAt:     `"2021-09-29T23:02:05Z"`,
+			lastMessageAt: `"yesterday"`,
+			wantErr:       `lastMessageAt: parsing time "yesterday" as "2006-01-02T15:04:05Z07:00": cannot parse "yesterday" as "2006"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := `{"id":"masked-1","createdAt":` + tt.createdAt
+			if tt.lastMessageAt != "" {
+				in += `,"lastMessageAt":` + tt.lastMessageAt
+			}
+			in += "}"
+
+			var email MaskedEmail
+			err := json.Unmarshal([]byte(in), &email)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !email.CreatedAt.Equal(tt.wantCreatedAt) || email.CreatedAt.Location() != time.UTC {
+				t.Errorf("got createdAt %v, want %v in UTC", email.CreatedAt, tt.wantCreatedAt)
+			}
+			switch {
+			case tt.wantLastMessageAt == nil && email.LastMessageAt != nil:
+				t.Errorf("got lastMessageAt %v, want nil", email.LastMessageAt)
+			case tt.wantLastMessageAt != nil && (email.LastMessageAt == nil || !email.LastMessageAt.Equal(*tt.wantLastMessageAt)):
+				t.Errorf("got lastMessageAt %v, want %v", email.LastMessageAt, tt.wantLastMessageAt)
+			}
+
+			out, err := json.Marshal(email)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var encoded struct {
+				CreatedAt     json.RawMessage `json:"createdAt"`
+				LastMessageAt json.RawMessage `json:"lastMessageAt"`
+			}
+			if err := json.Unmarshal(out, &encoded); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(encoded.CreatedAt) + " " + string(encoded.LastMessageAt); got != tt.wantJSON {
+				t.Errorf("got encoded times %s, want %s", got, tt.wantJSON)
+			}
+
+			// the encoding decodes to the same masked email
+			var again MaskedEmail
+			if err := json.Unmarshal(out, &again); err != nil {
+				t.Fatal(err)
+			}
+			if again2, err := json.Marshal(again); err != nil || !bytes.Equal(again2, out) {
+				t.Errorf("round trip changed the masked email\ngot:  %s\nwant: %s", again2, out)
+			}
+		})
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func TestUpdateOptionsPadEmptyValues(t *testing.T) {
+	payload := &UpdatePayload{}
+	WithUpdateDomain("")(payload)
+	WithUpdateDescription("")(payload)
+
+	if payload.Domain != " " || payload.Description != " " {
+		t.Errorf("empty values must be sent as a single space, got domain %q and description %q", payload.Domain, payload.Description)
+	}
+}
+
+// FuzzAPIResponse checks that parsing arbitrary response bodies returns errors
+// instead of panicking.
+func FuzzAPIResponse(f *testing.F) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "responses", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, input := range inputs {
+		body, err := os.ReadFile(input)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(body)
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		decoded, err := decodeResponses(body)
+		if err != nil {
+			return
+		}
+
+		for _, d := range decoded {
+			if _, err := json.Marshal(d); err != nil {
+				t.Errorf("decoded response can't be encoded: %v", err)
+			}
+		}
+	})
+}
+
+// FuzzMethodCall checks that method calls always marshal to a valid
+// [name, payload, callID] triple that round-trips.
+func FuzzMethodCall(f *testing.F) {
+	f.Add("MaskedEmail/set", "u1234", "masked-1", "github.com", "GitHub", "0")
+	f.Add("MaskedEmail/set", "", "", "", "", "")
+	f.Add(" ", "\"", "\\", "\x00", "\xff", "</script>")
+
+	f.Fuzz(func(t *testing.T, name, accID, id, domain, description, callID string) {
+		call := MethodCall{name, NewMethodCallUpdate(accID, id, WithUpdateDomain(domain), WithUpdateDescription(description)), callID}
+
+		data, err := json.Marshal(&call)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var triple []json.RawMessage
+		if err := json.Unmarshal(data, &triple); err != nil {
+			t.Fatalf("invalid json %s: %v", data, err)
+		}
+		if len(triple) != 3 {
+			t.Fatalf("expected 3 elements, got %d", len(triple))
+		}
+
+		var gotName, gotCallID string
+		var payload MethodCallUpdate
+		if err := json.Unmarshal(triple[0], &gotName); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(triple[1], &payload); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(triple[2], &gotCallID); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(payload.Update) != 1 {
+			t.Fatalf("expected 1 update in %s", data)
+		}
+		for _, update := range payload.Update {
+			if update.Domain == "" || update.Description == "" {
+				t.Errorf("empty domain or description in %s", data)
+			}
+		}
+
+		// invalid utf-8 is replaced when encoding
+		if !utf8.ValidString(name + callID + id) {
+			return
+		}
+		if gotName != name || gotCallID != callID {
+			t.Errorf("got name %q and call ID %q, want %q and %q", gotName, gotCallID, name, callID)
+		}
+		if _, ok := payload.Update[id]; !ok {
+			t.Errorf("update for %q missing in %s", id, data)
+		}
+	})
+}
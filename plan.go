@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+// desiredKey is the key of the masked email list in a desired state file.
+const desiredKey = "maskedemails"
+
+// desiredState is the file read by plan and apply:
+//
+//	maskedemails:
+//	  - email: 123@mydomain.com
+//	    forDomain: github.com
+//	    description: GitHub
+//	    url: https://github.com/login
+//	  - forDomain: example.com # created by apply, which adds id and email
+//	    description: Example
+//	    state: disabled
+//
+// Fields that are left out are not changed, masked emails that aren't listed
+// are left alone.
+type desiredState struct {
+	MaskedEmails []*desiredMaskedEmail `yaml:"maskedemails"`
+}
+
+// desiredMaskedEmail is matched with an existing masked email by ID or
+// address, or created if it has neither. Entries to create can't be deleted.
+type desiredMaskedEmail struct {
+	ID          string  `yaml:"id,omitempty"`
+	Email       string  `yaml:"email,omitempty"`
+	Domain      *string `yaml:"forDomain,omitempty"`
+	Description *string `yaml:"description,omitempty"`
+	State       *string `yaml:"state,omitempty"`
+	URL         *string `yaml:"url,omitempty"`
+	// EmailPrefix is only used when creating the masked email.
+	EmailPrefix string `yaml:"emailPrefix,omitempty"`
+}
+
+// fieldChange is a changed field of a planned action.
+type fieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+const (
+	planActionCreate = "create"
+	planActionUpdate = "update"
+)
+
+// planAction is a change to a single masked email. It's also the output
+// record of plan and apply.
+type planAction struct {
+	Action  string         `json:"action"`
+	ID      string         `json:"id,omitempty"`
+	Email   string         `json:"email,omitempty"`
+	Changes []*fieldChange `json:"changes"`
+
+	// index is the position of the entry in the file
+	index int
+	entry *desiredMaskedEmail
+	opts  []pkg.UpdateOption
+}
+
+func planActionColumn(header, field string, value func(a *planAction) string) column {
+	return column{
+		header: header,
+		field:  field,
+		value: func(record interface{}) string {
+			return value(record.(*planAction))
+		},
+	}
+}
+
+var planActionColumns = []column{
+	planActionColumn("Action", "action", func(a *planAction) string { return a.Action }),
+	planActionColumn("ID", "id", func(a *planAction) string { return a.ID }),
+	planActionColumn("Masked Email", "email", func(a *planAction) string { return a.Email }),
+	planActionColumn("Changes", "changes", func(a *planAction) string {
+		changes := make([]string, len(a.Changes))
+		for i, c := range a.Changes {
+			changes[i] = fmt.Sprintf("%s: %q -> %q", c.Field, c.From, c.To)
+		}
+		return strings.Join(changes, "; ")
+	}),
+}
+
+// loadDesiredState reads the desired state file, returning its yaml document
+// as well so apply can write back to it keeping comments and order.
+func loadDesiredState(path string) (*desiredState, *yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	state := &desiredState{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(state); err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	return state, &doc, nil
+}
+
+// planDesiredState compares the desired state against the current masked
+// emails and returns the actions to reach it.
+func planDesiredState(desired *desiredState, current []*pkg.MaskedEmail) ([]*planAction, error) {
+	byID := make(map[string]*pkg.MaskedEmail, len(current))
+	byEmail := make(map[string]*pkg.MaskedEmail, len(current))
+	for _, email := range current {
+		byID[email.ID] = email
+		byEmail[strings.ToLower(email.Email)] = email
+	}
+
+	var actions []*planAction
+	matched := map[string]int{}
+
+	for i, entry := range desired.MaskedEmails {
+		if entry == nil {
+			continue
+		}
+
+		if entry.State != nil {
+			switch pkg.MaskedEmailState(*entry.State) {
+			case pkg.MaskedEmailStateEnabled, pkg.MaskedEmailStateDisabled, pkg.MaskedEmailStateDeleted, pkg.MaskedEmailStatePending:
+			default:
+				return nil, fmt.Errorf("entry %d: invalid state %q", i+1, *entry.State)
+			}
+		}
+
+		if entry.ID == "" && entry.Email == "" {
+			if valueOr(entry.State, "") == string(pkg.MaskedEmailStateDeleted) {
+				return nil, fmt.Errorf("entry %d: state %s needs the id or email of the masked email to delete", i+1, pkg.MaskedEmailStateDeleted)
+			}
+
+			action := &planAction{Action: planActionCreate, index: i, entry: entry}
+			action.Changes = append(action.Changes, &fieldChange{Field: "forDomain", To: valueOr(entry.Domain, "")})
+			action.Changes = append(action.Changes, &fieldChange{Field: "description", To: valueOr(entry.Description, "")})
+			action.Changes = append(action.Changes, &fieldChange{Field: "state", To: valueOr(entry.State, string(pkg.MaskedEmailStateEnabled))})
+			if valueOr(entry.URL, "") != "" {
+				action.Changes = append(action.Changes, &fieldChange{Field: "url", To: valueOr(entry.URL, "")})
+			}
+			if entry.EmailPrefix != "" {
+				action.Changes = append(action.Changes, &fieldChange{Field: "emailPrefix", To: entry.EmailPrefix})
+			}
+
+			actions = append(actions, action)
+			continue
+		}
+
+		email, ok := byID[entry.ID]
+		if !ok && entry.ID == "" {
+			email, ok = byEmail[strings.ToLower(entry.Email)]
+		}
+		if !ok {
+			return nil, fmt.Errorf("entry %d: masked email %s not found", i+1, firstNonEmpty(entry.ID, entry.Email))
+		}
+
+		if prev, ok := matched[email.ID]; ok {
+			return nil, fmt.Errorf("entries %d and %d both refer to %s", prev+1, i+1, email.Email)
+		}
+		matched[email.ID] = i
+
+		action := &planAction{Action: planActionUpdate, ID: email.ID, Email: email.Email, index: i, entry: entry}
+		if entry.Domain != nil && strings.TrimSpace(*entry.Domain) != strings.TrimSpace(email.Domain) {
+			action.Changes = append(action.Changes, &fieldChange{Field: "forDomain", From: strings.TrimSpace(email.Domain), To: strings.TrimSpace(*entry.Domain)})
+			action.opts = append(action.opts, pkg.WithUpdateDomain(strings.TrimSpace(*entry.Domain)))
+		}
+		if entry.Description != nil && strings.TrimSpace(*entry.Description) != strings.TrimSpace(email.Description) {
+			action.Changes = append(action.Changes, &fieldChange{Field: "description", From: strings.TrimSpace(email.Description), To: strings.TrimSpace(*entry.Description)})
+			action.opts = append(action.opts, pkg.WithUpdateDescription(strings.TrimSpace(*entry.Description)))
+		}
+		if entry.URL != nil && strings.TrimSpace(*entry.URL) != strings.TrimSpace(email.URL) {
+			action.Changes = append(action.Changes, &fieldChange{Field: "url", From: strings.TrimSpace(email.URL), To: strings.TrimSpace(*entry.URL)})
+			action.opts = append(action.opts, pkg.WithUpdateURL(strings.TrimSpace(*entry.URL)))
+		}
+		if entry.State != nil && pkg.MaskedEmailState(*entry.State) != email.State {
+			if pkg.MaskedEmailState(*entry.State) == pkg.MaskedEmailStatePending {
+				return nil, fmt.Errorf("entry %d: %s can't become pending again", i+1, email.Email)
+			}
+			action.Changes = append(action.Changes, &fieldChange{Field: "state", From: string(email.State), To: *entry.State})
+			action.opts = append(action.opts, pkg.WithUpdateState(pkg.MaskedEmailState(*entry.State)))
+		}
+
+		if len(action.Changes) > 0 {
+			actions = append(actions, action)
+		}
+	}
+
+	return actions, nil
+}
+
+func valueOr(v *string, fallback string) string {
+	if v == nil {
+		return fallback
+	}
+
+	return strings.TrimSpace(*v)
+}
+
+// printPlan prints the actions, as a diff in text output.
+func printPlan(actions []*planAction, applied bool) error {
+	records := make([]interface{}, len(actions))
+	for i, a := range actions {
+		records[i] = a
+	}
+
+	return out.print(records, planActionColumns, func(w io.Writer) {
+		creates, updates := 0, 0
+		for _, a := range actions {
+			switch a.Action {
+			case planActionCreate:
+				creates++
+				if a.Email != "" {
+					fmt.Fprintf(w, "+ create %s (%s)\n", a.Email, a.ID)
+				} else {
+					fmt.Fprintf(w, "+ create masked email (entry %d)\n", a.index+1)
+				}
+				for _, c := range a.Changes {
+					fmt.Fprintf(w, "    %-12s %q\n", c.Field+":", c.To)
+				}
+			case planActionUpdate:
+				updates++
+				fmt.Fprintf(w, "~ update %s (%s)\n", a.Email, a.ID)
+				for _, c := range a.Changes {
+					fmt.Fprintf(w, "    %-12s %q -> %q\n", c.Field+":", c.From, c.To)
+				}
+			}
+		}
+
+		if len(actions) == 0 {
+			fmt.Fprintln(w, "No changes, masked emails match the file.")
+			return
+		}
+		if applied {
+			fmt.Fprintf(w, "\nApply complete: %d created, %d updated.\n", creates, updates)
+			return
+		}
+		fmt.Fprintf(w, "\nPlan: %d to create, %d to update.\n", creates, updates)
+	})
+}
+
+// runPlan prints the actions needed to reach the desired state of the file.
+func runPlan(ctx context.Context, client *pkg.Client, path string) error {
+	desired, _, err := loadDesiredState(path)
+	if err != nil {
+		return err
+	}
+
+	session, err := client.SessionContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	current, err := client.GetAllMaskedEmailsContext(ctx, session, *flagAccountID, true)
+	if err != nil {
+		return err
+	}
+
+	actions, err := planDesiredState(desired, current)
+	if err != nil {
+		return err
+	}
+
+	return printPlan(actions, false)
+}
+
+// runApply reaches the desired state of the file with at most two
+// MaskedEmail/set calls, one creating and one updating masked emails. The id
+// and email of created masked emails are written back to the file.
+func runApply(ctx context.Context, client *pkg.Client, path string) error {
+	desired, doc, err := loadDesiredState(path)
+	if err != nil {
+		return err
+	}
+
+	session, err := client.SessionContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	current, err := client.GetAllMaskedEmailsContext(ctx, session, *flagAccountID, true)
+	if err != nil {
+		return err
+	}
+
+	actions, err := planDesiredState(desired, current)
+	if err != nil {
+		return err
+	}
+
+	var creates []*planAction
+	var specs []pkg.CreateSpec
+	updates := map[string][]pkg.UpdateOption{}
+	for _, a := range actions {
+		switch a.Action {
+		case planActionCreate:
+			state := pkg.MaskedEmailState(valueOr(a.entry.State, string(pkg.MaskedEmailStateEnabled)))
+			creates = append(creates, a)
+			specs = append(specs, pkg.CreateSpec{
+				Domain:      valueOr(a.entry.Domain, ""),
+				Description: valueOr(a.entry.Description, ""),
+				EmailPrefix: a.entry.EmailPrefix,
+				URL:         valueOr(a.entry.URL, ""),
+				Enabled:     state != pkg.MaskedEmailStatePending,
+			})
+		case planActionUpdate:
+			updates[a.ID] = a.opts
+		}
+	}
+
+	var failed pkg.SetErrors
+	applied := []*planAction{}
+
+	if len(specs) > 0 {
+		created, err := client.CreateMaskedEmailsContext(ctx, session, *flagAccountID, specs)
+		if errors.As(err, &failed) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		for i, email := range created {
+			if email == nil {
+				continue
+			}
+
+			a := creates[i]
+			a.ID, a.Email = email.ID, email.Email
+			applied = append(applied, a)
+
+			if pkg.MaskedEmailState(valueOr(a.entry.State, "")) == pkg.MaskedEmailStateDisabled {
+				// masked emails can only be created enabled or pending
+				updates[email.ID] = append(updates[email.ID], pkg.WithUpdateState(pkg.MaskedEmailStateDisabled))
+			}
+
+			setDesiredValue(doc, a.index, "email", email.Email)
+			setDesiredValue(doc, a.index, "id", email.ID)
+		}
+
+		if len(applied) > 0 {
+			if err := writeDesiredState(path, doc); err != nil {
+				return fmt.Errorf("writing created masked emails to %s: %w", path, err)
+			}
+		}
+	}
+
+	if len(updates) > 0 {
+		res, err := client.UpdateMaskedEmailsContext(ctx, session, *flagAccountID, updates)
+		var setErrs pkg.SetErrors
+		if errors.As(err, &setErrs) {
+			failed = append(failed, setErrs...)
+		} else if err != nil {
+			return err
+		}
+
+		for _, a := range actions {
+			if _, ok := res.Updated[a.ID]; ok && a.Action == planActionUpdate {
+				applied = append(applied, a)
+			}
+		}
+	}
+
+	if err := printPlan(applied, true); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		for _, setErr := range failed {
+			log.Printf("error applying %v", setErr)
+		}
+		return failed
+	}
+
+	return nil
+}
+
+// setDesiredValue sets a field of an entry in the desired state document,
+// adding it at the top of the entry if it's missing.
+func setDesiredValue(doc *yaml.Node, index int, key string, value string) {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return
+	}
+
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != desiredKey || root.Content[i+1].Kind != yaml.SequenceNode {
+			continue
+		}
+
+		entries := root.Content[i+1].Content
+		if index >= len(entries) || entries[index].Kind != yaml.MappingNode {
+			return
+		}
+
+		entry := entries[index]
+		for j := 0; j+1 < len(entry.Content); j += 2 {
+			if entry.Content[j].Value == key {
+				entry.Content[j+1].SetString(value)
+				return
+			}
+		}
+
+		keyNode, valueNode := &yaml.Node{}, &yaml.Node{}
+		keyNode.SetString(key)
+		valueNode.SetString(value)
+		entry.Content = append([]*yaml.Node{keyNode, valueNode}, entry.Content...)
+		return
+	}
+}
+
+// writeDesiredState writes the document back to the file, keeping its
+// permissions.
+func writeDesiredState(path string, doc *yaml.Node) error {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	return os.WriteFile(path, buf.Bytes(), mode)
+}
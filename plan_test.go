@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestPlanDesiredState(t *testing.T) {
+	current := []*pkg.MaskedEmail{
+		{ID: "masked-1", Email: "a@example.com", Domain: "github.com", URL: "https://github.com ", State: pkg.MaskedEmailStateEnabled},
+		{ID: "masked-2", Email: "b@example.com", Description: "old", State: pkg.MaskedEmailStateEnabled},
+	}
+
+	desired := &desiredState{MaskedEmails: []*desiredMaskedEmail{
+		{Email: "A@example.com", URL: strPtr("https://github.com/login")},
+		{ID: "masked-2", Description: strPtr("old"), URL: strPtr(""), State: strPtr("deleted")},
+		{Domain: strPtr("example.com"), URL: strPtr(" https://example.com "), State: strPtr("pending")},
+		{Email: "a@example.com", URL: strPtr("https://github.com/login")},
+	}}
+
+	if _, err := planDesiredState(desired, current); err == nil || err.Error() != "entries 1 and 4 both refer to a@example.com" {
+		t.Fatalf("got error %v, want duplicate entries", err)
+	}
+
+	desired.MaskedEmails = desired.MaskedEmails[:3]
+	actions, err := planDesiredState(desired, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*planAction{
+		{Action: planActionUpdate, ID: "masked-1", Email: "a@example.com", Changes: []*fieldChange{
+			{Field: "url", From: "https://github.com", To: "https://github.com/login"},
+		}},
+		{Action: planActionUpdate, ID: "masked-2", Email: "b@example.com", Changes: []*fieldChange{
+			{Field: "state", From: "enabled", To: "deleted"},
+		}},
+		{Action: planActionCreate, Changes: []*fieldChange{
+			{Field: "forDomain", To: "example.com"},
+			{Field: "description"},
+			{Field: "state", To: "pending"},
+			{Field: "url", To: "https://example.com"},
+		}},
+	}
+
+	if len(actions) != len(want) {
+		t.Fatalf("got %d actions, want %d", len(actions), len(want))
+	}
+	for i, a := range actions {
+		if a.Action != want[i].Action || a.ID != want[i].ID || a.Email != want[i].Email || !reflect.DeepEqual(a.Changes, want[i].Changes) {
+			t.Errorf("action %d: got %s %s %s %v, want %s %s %s %v", i, a.Action, a.ID, a.Email, a.Changes, want[i].Action, want[i].ID, want[i].Email, want[i].Changes)
+		}
+		if a.Action == planActionUpdate && len(a.opts) != len(a.Changes) {
+			t.Errorf("action %d: got %d update options for %d changes", i, len(a.opts), len(a.Changes))
+		}
+	}
+}
+
+func TestPlanDesiredStateErrors(t *testing.T) {
+	current := []*pkg.MaskedEmail{
+		{ID: "masked-1", Email: "a@example.com", State: pkg.MaskedEmailStateEnabled},
+	}
+
+	tests := []struct {
+		name  string
+		entry *desiredMaskedEmail
+		want  string
+	}{
+		{
+			name:  "delete without id or email",
+			entry: &desiredMaskedEmail{Domain: strPtr("example.com"), State: strPtr("deleted")},
+			want:  "entry 1: state deleted needs the id or email of the masked email to delete",
+		},
+		{
+			name:  "invalid state",
+			entry: &desiredMaskedEmail{ID: "masked-1", State: strPtr("paused")},
+			want:  `entry 1: invalid state "paused"`,
+		},
+		{
+			name:  "not found",
+			entry: &desiredMaskedEmail{Email: "z@example.com"},
+			want:  "entry 1: masked email z@example.com not found",
+		},
+		{
+			name:  "pending again",
+			entry: &desiredMaskedEmail{ID: "masked-1", State: strPtr("pending")},
+			want:  "entry 1: a@example.com can't become pending again",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := planDesiredState(&desiredState{MaskedEmails: []*desiredMaskedEmail{tt.entry}}, current)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// maxRequestBodySize limits the size of JSON request bodies.
+	maxRequestBodySize = 64 << 10
+	// rateLimitBurst is how many requests a client may send at once, unless
+	// the rate per minute is lower.
+	rateLimitBurst = 10
+)
+
+// apiClient is a client of the REST API, as configured in the keys file:
+//
+//   - name: firefox
+//     key_sha256: 5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8
+//     appname: firefox-extension
+//   - name: home-assistant
+//     key: some-long-random-key
+type apiClient struct {
+	Name string `yaml:"name"`
+	// Key is the API key, KeySHA256 its hex encoded SHA-256 hash so the keys
+	// file doesn't have to hold the key itself.
+	Key       string `yaml:"key,omitempty"`
+	KeySHA256 string `yaml:"key_sha256,omitempty"`
+	// AppName identifies the client when creating masked emails, defaults to
+	// the name.
+	AppName string `yaml:"appname,omitempty"`
+
+	keyHash []byte
+}
+
+// loadAPIClients reads the keys file of the serve command.
+func loadAPIClients(path string) ([]*apiClient, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []*apiClient
+	if err := yaml.Unmarshal(data, &clients); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for _, c := range clients {
+		switch {
+		case c.Name == "":
+			return nil, fmt.Errorf("%s: client without name", path)
+		case names[c.Name]:
+			return nil, fmt.Errorf("%s: duplicate client %q", path, c.Name)
+		case c.Key != "":
+			sum := sha256.Sum256([]byte(c.Key))
+			c.keyHash = sum[:]
+		case c.KeySHA256 != "":
+			c.keyHash, err = hex.DecodeString(c.KeySHA256)
+			if err != nil || len(c.keyHash) != sha256.Size {
+				return nil, fmt.Errorf("%s: invalid key_sha256 of client %q", path, c.Name)
+			}
+		default:
+			return nil, fmt.Errorf("%s: client %q has no key", path, c.Name)
+		}
+		names[c.Name] = true
+
+		if c.AppName == "" {
+			c.AppName = c.Name
+		}
+	}
+
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("%s: no clients configured", path)
+	}
+
+	return clients, nil
+}
+
+// rateLimiter is a token bucket per API client.
+type rateLimiter struct {
+	mu sync.Mutex
+	// perSecond is the rate tokens are refilled at, burst the size of the
+	// buckets
+	perSecond float64
+	burst     float64
+	buckets   map[string]*rateBucket
+	now       func() time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	burst := rateLimitBurst
+	if perMinute < burst {
+		burst = perMinute
+	}
+
+	return &rateLimiter{
+		perSecond: float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   map[string]*rateBucket{},
+		now:       time.Now,
+	}
+}
+
+// allow takes a token of the client's bucket, if there is one.
+func (l *rateLimiter) allow(name string) bool {
+	if l.perSecond <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[name]
+	if !ok {
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[name] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.perSecond
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+
+	return true
+}
+
+// auditEntry is a line of the audit log.
+type auditEntry struct {
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client,omitempty"`
+	RemoteAddr string    `json:"remoteAddr"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Action     string    `json:"action,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Status     int       `json:"status"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// auditLog writes one JSON object per request.
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (a *auditLog) write(entry *auditEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.enc.Encode(entry); err != nil {
+		log.Printf("writing audit log: %v", err)
+	}
+}
+
+// apiServer exposes masked email operations over a REST API:
+//
+//	GET   /v1/maskedemails[?state=enabled,disabled&domain=<domain>]
+//	POST  /v1/maskedemails        {"forDomain", "description", "emailPrefix", "url", "state"}
+//	GET   /v1/maskedemails/<maskedemail|id>
+//	PATCH /v1/maskedemails/<maskedemail|id>  {"state", "forDomain", "description", "url"}
+//
+// Requests are authenticated with `Authorization: Bearer <key>`.
+type apiServer struct {
+	client  *pkg.Client
+	session *pkg.SessionResource
+	clients []*apiClient
+	limiter *rateLimiter
+	audit   *auditLog
+}
+
+// apiRequest carries the state of a request for the audit log.
+type apiRequest struct {
+	client *apiClient
+	entry  *auditEntry
+}
+
+// apiError is the body of error responses.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/maskedemails", s.handle(s.collection))
+	mux.HandleFunc("/v1/maskedemails/", s.handle(s.item))
+
+	return mux
+}
+
+// handle authenticates, rate limits and audits requests.
+func (s *apiServer) handle(next func(w http.ResponseWriter, r *http.Request, req *apiRequest) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req := &apiRequest{entry: &auditEntry{
+			Time:       time.Now().UTC(),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+		}}
+
+		status, err := s.serve(w, r, req, next)
+		if err != nil {
+			writeJSON(w, status, &apiError{Error: err.Error()})
+			req.entry.Error = err.Error()
+		}
+
+		req.entry.Status = status
+		s.audit.write(req.entry)
+	}
+}
+
+func (s *apiServer) serve(w http.ResponseWriter, r *http.Request, req *apiRequest, next func(w http.ResponseWriter, r *http.Request, req *apiRequest) (int, error)) (int, error) {
+	req.client = s.authenticate(r)
+	if req.client == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return http.StatusUnauthorized, errors.New("invalid or missing api key")
+	}
+	req.entry.Client = req.client.Name
+
+	if !s.limiter.allow(req.client.Name) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1/s.limiter.perSecond))))
+		return http.StatusTooManyRequests, errors.New("rate limit exceeded")
+	}
+
+	return next(w, r, req)
+}
+
+// authenticate returns the client matching the bearer key of the request.
+func (s *apiServer) authenticate(r *http.Request) *apiClient {
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if key == "" || key == r.Header.Get("Authorization") {
+		return nil
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	for _, c := range s.clients {
+		if subtle.ConstantTimeCompare(sum[:], c.keyHash) == 1 {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// collection handles /v1/maskedemails.
+func (s *apiServer) collection(w http.ResponseWriter, r *http.Request, req *apiRequest) (int, error) {
+	switch r.Method {
+	case http.MethodGet:
+		req.entry.Action = "list"
+		return s.list(w, r)
+	case http.MethodPost:
+		req.entry.Action = "create"
+		return s.create(w, r, req)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		return http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)
+	}
+}
+
+// item handles /v1/maskedemails/<maskedemail|id>.
+func (s *apiServer) item(w http.ResponseWriter, r *http.Request, req *apiRequest) (int, error) {
+	target := strings.TrimPrefix(r.URL.Path, "/v1/maskedemails/")
+	if target == "" || strings.Contains(target, "/") {
+		return http.StatusNotFound, errors.New("not found")
+	}
+	req.entry.Target = target
+
+	switch r.Method {
+	case http.MethodGet:
+		req.entry.Action = "get"
+		email, err := s.client.GetMaskedEmailContext(r.Context(), s.session, *flagAccountID, target)
+		if err != nil {
+			return apiErrorStatus(err), err
+		}
+
+		writeJSON(w, http.StatusOK, maskedEmailRecord(email))
+		return http.StatusOK, nil
+
+	case http.MethodPatch:
+		req.entry.Action = "update"
+		return s.update(w, r, target)
+
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		return http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)
+	}
+}
+
+func (s *apiServer) list(w http.ResponseWriter, r *http.Request) (int, error) {
+	query := r.URL.Query()
+	filter := pkg.MaskedEmailFilter{Domain: query.Get("domain")}
+
+	includeDeleted := false
+	if states := query.Get("state"); states != "" {
+		for _, state := range strings.Split(states, ",") {
+			filter.States = append(filter.States, pkg.MaskedEmailState(strings.TrimSpace(state)))
+			includeDeleted = includeDeleted || strings.TrimSpace(state) == string(pkg.MaskedEmailStateDeleted)
+		}
+	}
+
+	emails, err := s.client.GetAllMaskedEmailsContext(r.Context(), s.session, *flagAccountID, includeDeleted)
+	if err != nil {
+		return apiErrorStatus(err), err
+	}
+
+	writeJSON(w, http.StatusOK, maskedEmailRecords(pkg.FilterMaskedEmails(emails, filter)))
+	return http.StatusOK, nil
+}
+
+// createRequest is the body of a create request. State is "enabled" (the
+// default) or "pending".
+type createRequest struct {
+	Domain      string               `json:"forDomain"`
+	Description string               `json:"description"`
+	EmailPrefix string               `json:"emailPrefix"`
+	URL         string               `json:"url"`
+	State       pkg.MaskedEmailState `json:"state"`
+}
+
+func (s *apiServer) create(w http.ResponseWriter, r *http.Request, req *apiRequest) (int, error) {
+	var body createRequest
+	if err := decodeBody(w, r, &body); err != nil {
+		return http.StatusBadRequest, err
+	}
+	req.entry.Target = body.Domain
+
+	var enabled bool
+	switch body.State {
+	case "", pkg.MaskedEmailStateEnabled:
+		enabled = true
+	case pkg.MaskedEmailStatePending:
+		enabled = false
+	default:
+		return http.StatusBadRequest, fmt.Errorf("invalid state %q, must be enabled or pending", body.State)
+	}
+
+	client := s.client.WithAppName(req.client.AppName)
+	created, err := client.CreateMaskedEmailFromSpecContext(r.Context(), s.session, *flagAccountID, pkg.CreateSpec{
+		Domain:      body.Domain,
+		Description: body.Description,
+		EmailPrefix: body.EmailPrefix,
+		URL:         body.URL,
+		Enabled:     enabled,
+	})
+	if err != nil {
+		return apiErrorStatus(err), err
+	}
+	req.entry.Target = created.Email
+
+	email, err := client.GetMaskedEmailContext(r.Context(), s.session, *flagAccountID, created.ID)
+	if err != nil {
+		return apiErrorStatus(err), err
+	}
+
+	writeJSON(w, http.StatusCreated, maskedEmailRecord(email))
+	return http.StatusCreated, nil
+}
+
+// updateRequest is the body of an update request, only set fields are
+// updated.
+type updateRequest struct {
+	State       *pkg.MaskedEmailState `json:"state"`
+	Domain      *string               `json:"forDomain"`
+	Description *string               `json:"description"`
+	URL         *string               `json:"url"`
+}
+
+func (s *apiServer) update(w http.ResponseWriter, r *http.Request, target string) (int, error) {
+	var body updateRequest
+	if err := decodeBody(w, r, &body); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	opts := []pkg.UpdateOption{}
+	if body.State != nil {
+		switch *body.State {
+		case pkg.MaskedEmailStateEnabled, pkg.MaskedEmailStateDisabled, pkg.MaskedEmailStateDeleted:
+			opts = append(opts, pkg.WithUpdateState(*body.State))
+		default:
+			return http.StatusBadRequest, fmt.Errorf("invalid state %q, must be enabled, disabled or deleted", *body.State)
+		}
+	}
+	if body.Domain != nil {
+		opts = append(opts, pkg.WithUpdateDomain(strings.TrimSpace(*body.Domain)))
+	}
+	if body.Description != nil {
+		opts = append(opts, pkg.WithUpdateDescription(strings.TrimSpace(*body.Description)))
+	}
+	if body.URL != nil {
+		opts = append(opts, pkg.WithUpdateURL(strings.TrimSpace(*body.URL)))
+	}
+	if len(opts) == 0 {
+		return http.StatusBadRequest, errors.New("nothing to update")
+	}
+
+	id, err := s.lookupID(r.Context(), target)
+	if err != nil {
+		return apiErrorStatus(err), err
+	}
+
+	if _, err := s.client.UpdateMaskedEmailContext(r.Context(), s.session, *flagAccountID, id, opts...); err != nil {
+		return apiErrorStatus(err), err
+	}
+
+	email, err := s.client.GetMaskedEmailContext(r.Context(), s.session, *flagAccountID, id)
+	if err != nil {
+		return apiErrorStatus(err), err
+	}
+
+	writeJSON(w, http.StatusOK, maskedEmailRecord(email))
+	return http.StatusOK, nil
+}
+
+// lookupID returns the ID of the masked email, which must exist.
+func (s *apiServer) lookupID(ctx context.Context, target string) (string, error) {
+	email, err := s.client.GetMaskedEmailContext(ctx, s.session, *flagAccountID, target)
+	if err != nil {
+		return "", err
+	}
+
+	return email.ID, nil
+}
+
+// apiErrorStatus maps errors of the API client to a response status.
+func apiErrorStatus(err error) int {
+	var notFound *pkg.NotFoundError
+	var setErr *pkg.SetError
+
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	case errors.As(err, &setErr) && setErr.Type == "notFound":
+		return http.StatusNotFound
+	case errors.As(err, &setErr) && setErr.Type == "invalidProperties":
+		return http.StatusBadRequest
+	case errors.As(err, &setErr):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// runServe serves the REST API until ctx is canceled.
+func runServe(ctx context.Context, client *pkg.Client, addr string, keysPath string, perMinute int, auditPath string) error {
+	if keysPath == "" {
+		return fmt.Errorf("missing -%s file with the api clients", flagNameKeys)
+	}
+
+	clients, err := loadAPIClients(keysPath)
+	if err != nil {
+		return err
+	}
+
+	var auditOut io.Writer = os.Stderr
+	if auditPath != "" {
+		f, err := os.OpenFile(auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return fmt.Errorf("opening audit log: %w", err)
+		}
+		defer f.Close()
+		auditOut = f
+	}
+
+	session, err := client.SessionContext(ctx)
+	if err != nil {
+		return fmt.Errorf("initializing session: %w", err)
+	}
+
+	s := &apiServer{
+		client:  client,
+		session: session,
+		clients: clients,
+		limiter: newRateLimiter(perMinute),
+		audit:   &auditLog{enc: json.NewEncoder(auditOut)},
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving %d api client(s) on http://%s", len(clients), listener.Addr())
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvcrn/maskedemail-cli/pkg"
+	"github.com/dvcrn/maskedemail-cli/pkg/jmaptest"
+)
+
+// testKeys configures a client with a plain key and one with the hash of
+// "ha-key".
+const testKeys = `
+- name: firefox
+  key: firefox-key
+  appname: firefox-extension
+- name: home-assistant
+  key_sha256: %x
+`
+
+// testAPIServer serves the REST API against a jmaptest server.
+type testAPIServer struct {
+	*httptest.Server
+	jmap    *jmaptest.Server
+	limiter *rateLimiter
+	audit   *bytes.Buffer
+}
+
+func newTestAPIServer(t *testing.T, perMinute int, opts ...jmaptest.Option) *testAPIServer {
+	t.Helper()
+
+	keys := fmt.Sprintf(testKeys, sha256.Sum256([]byte("ha-key")))
+	keysPath := filepath.Join(t.TempDir(), "keys.yaml")
+	if err := os.WriteFile(keysPath, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	clients, err := loadAPIClients(keysPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jmap, client := jmaptest.Start(t, opts...)
+	session, err := client.Session()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audit := &bytes.Buffer{}
+	s := &apiServer{
+		client:  client,
+		session: session,
+		clients: clients,
+		limiter: newRateLimiter(perMinute),
+		audit:   &auditLog{enc: json.NewEncoder(audit)},
+	}
+
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+
+	return &testAPIServer{Server: ts, jmap: jmap, limiter: s.limiter, audit: audit}
+}
+
+// do sends a request with the api key and decodes the response into out, if
+// set.
+func (s *testAPIServer) do(t *testing.T, method, path, key, body string, out interface{}) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+
+	res, err := s.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return res
+}
+
+// auditEntries returns the entries written to the audit log so far.
+func (s *testAPIServer) auditEntries(t *testing.T) []auditEntry {
+	t.Helper()
+
+	var entries []auditEntry
+	dec := json.NewDecoder(bytes.NewReader(s.audit.Bytes()))
+	for dec.More() {
+		var entry auditEntry
+		if err := dec.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestServeAuth(t *testing.T) {
+	s := newTestAPIServer(t, 0, jmaptest.WithMaskedEmails(&pkg.MaskedEmail{Email: "a@example.com", Domain: "github.com"}))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{name: "missing", header: "", want: http.StatusUnauthorized},
+		{name: "wrong key", header: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "not bearer", header: "Basic firefox-key", want: http.StatusUnauthorized},
+		{name: "bare key", header: "firefox-key", want: http.StatusUnauthorized},
+		{name: "key", header: "Bearer firefox-key", want: http.StatusOK},
+		{name: "key sha256", header: "Bearer ha-key", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, s.URL+"/v1/maskedemails", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			res, err := s.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tt.want {
+				t.Errorf("got status %d, want %d", res.StatusCode, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("missing WWW-Authenticate header")
+			}
+		})
+	}
+
+	var emails []*pkg.MaskedEmail
+	s.do(t, http.MethodGet, "/v1/maskedemails", "firefox-key", "", &emails)
+	if len(emails) != 1 || emails[0].Email != "a@example.com" {
+		t.Errorf("unexpected masked emails: %+v", emails)
+	}
+}
+
+func TestLoadAPIClientsErrors(t *testing.T) {
+	tests := map[string]string{
+		"no clients":     "[]",
+		"no name":        "- key: k",
+		"no key":         "- name: a",
+		"duplicate":      "- {name: a, key: k}\n- {name: a, key: l}",
+		"invalid sha256": "- {name: a, key_sha256: abc}",
+	}
+
+	for name, keys := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.yaml")
+			if err := os.WriteFile(path, []byte(keys), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := loadAPIClients(path); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestServeRateLimit(t *testing.T) {
+	tests := []struct {
+		perMinute  int
+		burst      int
+		retryAfter string
+	}{
+		{perMinute: 1, burst: 1, retryAfter: "60"},
+		{perMinute: 3, burst: 3, retryAfter: "20"},
+		{perMinute: 120, burst: rateLimitBurst, retryAfter: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d per minute", tt.perMinute), func(t *testing.T) {
+			s := newTestAPIServer(t, tt.perMinute)
+
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			s.limiter.now = func() time.Time { return now }
+
+			for i := 0; i < tt.burst; i++ {
+				if res := s.do(t, http.MethodGet, "/v1/maskedemails", "firefox-key", "", nil); res.StatusCode != http.StatusOK {
+					t.Fatalf("request %d: got status %d", i+1, res.StatusCode)
+				}
+			}
+
+			res := s.do(t, http.MethodGet, "/v1/maskedemails", "firefox-key", "", nil)
+			if res.StatusCode != http.StatusTooManyRequests {
+				t.Fatalf("request after the burst: got status %d, want 429", res.StatusCode)
+			}
+			if got := res.Header.Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("got Retry-After %q, want %q", got, tt.retryAfter)
+			}
+
+			// other clients have their own bucket
+			if res := s.do(t, http.MethodGet, "/v1/maskedemails", "ha-key", "", nil); res.StatusCode != http.StatusOK {
+				t.Errorf("other client: got status %d", res.StatusCode)
+			}
+
+			// one token is refilled after Retry-After
+			now = now.Add(time.Minute / time.Duration(tt.perMinute))
+			if res := s.do(t, http.MethodGet, "/v1/maskedemails", "firefox-key", "", nil); res.StatusCode != http.StatusOK {
+				t.Errorf("after refill: got status %d", res.StatusCode)
+			}
+			if res := s.do(t, http.MethodGet, "/v1/maskedemails", "firefox-key", "", nil); res.StatusCode != http.StatusTooManyRequests {
+				t.Errorf("after using the refill: got status %d, want 429", res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestServeRateLimitDisabled(t *testing.T) {
+	s := newTestAPIServer(t, 0)
+
+	for i := 0; i < 2*rateLimitBurst; i++ {
+		if res := s.do(t, http.MethodGet, "/v1/maskedemails", "firefox-key", "", nil); res.StatusCode != http.StatusOK {
+			t.Fatalf("request %d: got status %d", i+1, res.StatusCode)
+		}
+	}
+}
+
+func TestServeAuditLog(t *testing.T) {
+	s := newTestAPIServer(t, 0)
+
+	var created pkg.MaskedEmail
+	res := s.do(t, http.MethodPost, "/v1/maskedemails", "firefox-key", `{"forDomain": "github.com", "url": "https://github.com/login"}`, &created)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("create: got status %d", res.StatusCode)
+	}
+	if created.Domain != "github.com" || created.URL != "https://github.com/login" || created.State != pkg.MaskedEmailStateEnabled {
+		t.Errorf("unexpected masked email: %+v", created)
+	}
+
+	var updated pkg.MaskedEmail
+	res = s.do(t, http.MethodPatch, "/v1/maskedemails/"+created.Email, "ha-key", `{"state": "disabled", "url": ""}`, &updated)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("update: got status %d", res.StatusCode)
+	}
+	if updated.State != pkg.MaskedEmailStateDisabled || updated.URL != "" {
+		t.Errorf("unexpected masked email: %+v", updated)
+	}
+
+	s.do(t, http.MethodPatch, "/v1/maskedemails/"+created.ID, "ha-key", `{"state": "pending"}`, nil)
+	s.do(t, http.MethodGet, "/v1/maskedemails/nope@example.com", "firefox-key", "", nil)
+	s.do(t, http.MethodDelete, "/v1/maskedemails/"+created.ID, "firefox-key", "", nil)
+	s.do(t, http.MethodGet, "/v1/maskedemails", "wrong-key", "", nil)
+
+	want := []auditEntry{
+		{Client: "firefox", Method: "POST", Path: "/v1/maskedemails", Action: "create", Target: created.Email, Status: http.StatusCreated},
+		{Client: "home-assistant", Method: "PATCH", Path: "/v1/maskedemails/" + created.Email, Action: "update", Target: created.Email, Status: http.StatusOK},
+		{Client: "home-assistant", Method: "PATCH", Path: "/v1/maskedemails/" + created.ID, Action: "update", Target: created.ID, Status: http.StatusBadRequest, Error: `invalid state "pending", must be enabled, disabled or deleted`},
+		{Client: "firefox", Method: "GET", Path: "/v1/maskedemails/nope@example.com", Action: "get", Target: "nope@example.com", Status: http.StatusNotFound, Error: "maskedemail nope@example.com not found"},
+		{Client: "firefox", Method: "DELETE", Path: "/v1/maskedemails/" + created.ID, Target: created.ID, Status: http.StatusMethodNotAllowed, Error: "method DELETE not allowed"},
+		{Method: "GET", Path: "/v1/maskedemails", Status: http.StatusUnauthorized, Error: "invalid or missing api key"},
+	}
+
+	entries := s.auditEntries(t)
+	if len(entries) != len(want) {
+		t.Fatalf("got %d audit entries, want %d: %+v", len(entries), len(want), entries)
+	}
+
+	for i, entry := range entries {
+		if entry.Time.IsZero() || entry.RemoteAddr == "" {
+			t.Errorf("entry %d: missing time or remote address: %+v", i, entry)
+		}
+
+		entry.Time, entry.RemoteAddr = time.Time{}, ""
+		if entry != want[i] {
+			t.Errorf("entry %d:\ngot  %+v\nwant %+v", i, entry, want[i])
+		}
+	}
+}
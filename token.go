@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// readToken returns the first non-empty line of r.
+func readToken(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", errors.New("no token found")
+}
+
+// readTokenFile reads the token from a file, which must not be accessible by
+// other users.
+func readTokenFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkTokenFilePermissions(path, fi); err != nil {
+		return "", err
+	}
+
+	token, err := readToken(f)
+	if err != nil {
+		return "", fmt.Errorf("reading token file %s: %w", path, err)
+	}
+
+	return token, nil
+}
+
+// writeTokenFile stores the token in a file only readable by the current user.
+func writeTokenFile(path string, token string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(token+"\n"), 0o600)
+}
+
+// managedTokenFile is where login stores the token of a profile when using
+// file storage, next to the config file.
+func managedTokenFile(configPath string, profileName string) string {
+	return filepath.Join(filepath.Dir(configPath), profileName+".token")
+}
+
+// runTokenCommand runs a shell command, eg. `pass show fastmail`, and returns
+// the first line of its output as token. The command doesn't get our stdin,
+// which may hold the targets of a -stdin command.
+func runTokenCommand(command string) (string, error) {
+	cmd := shellCommand(command)
+	cmd.Stderr = os.Stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running token command: %w", err)
+	}
+
+	token, err := readToken(bytes.NewReader(output))
+	if err != nil {
+		return "", fmt.Errorf("running token command: %w", err)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// checkTokenFilePermissions refuses token files that other users can access.
+func checkTokenFilePermissions(path string, fi os.FileInfo) error {
+	if perm := fi.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("token file %s is accessible by other users (mode %04o), run `chmod 600 %s`", path, perm, path)
+	}
+
+	return nil
+}
+
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("sh", "-c", command)
+}
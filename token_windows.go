@@ -0,0 +1,18 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// checkTokenFilePermissions is a no-op on windows, where files are protected
+// by ACLs rather than permission bits.
+func checkTokenFilePermissions(path string, fi os.FileInfo) error {
+	return nil
+}
+
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
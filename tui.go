@@ -0,0 +1,606 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dvcrn/maskedemail-cli/pkg"
+	"github.com/mattn/go-runewidth"
+)
+
+// tuiMode is what key presses currently do in the TUI.
+type tuiMode int
+
+const (
+	tuiModeBrowse tuiMode = iota
+	tuiModeSearch
+	tuiModeEditDescription
+	tuiModeEditDomain
+	tuiModeCreate
+	tuiModeConfirmDelete
+)
+
+// tuiSortFields are cycled through with the sort key.
+var tuiSortFields = []pkg.SortField{
+	pkg.SortByCreatedAt,
+	pkg.SortByEmail,
+	pkg.SortByDomain,
+	pkg.SortByDescription,
+	pkg.SortByState,
+	pkg.SortByLastMessageAt,
+}
+
+const tuiHelp = "/ search  s/S sort  e enable  d disable  x delete  E description  F domain  n new  c copy  a deleted  r reload  q quit"
+
+var (
+	tuiHeaderStyle   = lipgloss.NewStyle().Bold(true)
+	tuiSelectedStyle = lipgloss.NewStyle().Reverse(true)
+	tuiStatusStyle   = lipgloss.NewStyle().Faint(true)
+	tuiErrorStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+)
+
+// tuiLoadedMsg is sent when all masked emails have been fetched.
+type tuiLoadedMsg struct {
+	session *pkg.SessionResource
+	emails  []*pkg.MaskedEmail
+	err     error
+}
+
+// tuiChangedMsg is sent when a masked email was created or updated.
+type tuiChangedMsg struct {
+	email  *pkg.MaskedEmail
+	status string
+	err    error
+}
+
+// tuiStatusMsg shows a message in the status line.
+type tuiStatusMsg struct {
+	status string
+	err    error
+}
+
+// tuiModel is the state of the TUI, see runTUI.
+type tuiModel struct {
+	ctx     context.Context
+	client  *pkg.Client
+	session *pkg.SessionResource
+
+	// emails are all masked emails, rows the ones shown after searching and
+	// sorting
+	emails []*pkg.MaskedEmail
+	rows   []*pkg.MaskedEmail
+
+	cursor int
+	offset int
+	width  int
+	height int
+
+	sortIndex   int
+	sortDesc    bool
+	showDeleted bool
+	query       string
+
+	mode  tuiMode
+	input textinput.Model
+	// deleteID and deleteEmail are the masked email to delete in
+	// tuiModeConfirmDelete, as the selection may change meanwhile
+	deleteID    string
+	deleteEmail string
+	// editID and editEmail are the masked email to update in
+	// tuiModeEditDescription and tuiModeEditDomain, for the same reason
+	editID    string
+	editEmail string
+
+	loading bool
+	status  string
+	err     error
+}
+
+// runTUI starts the interactive interface for browsing and managing masked
+// emails.
+func runTUI(ctx context.Context, client *pkg.Client) error {
+	input := textinput.New()
+	input.CharLimit = 256
+
+	m := &tuiModel{
+		ctx:      ctx,
+		client:   client,
+		input:    input,
+		sortDesc: true,
+		loading:  true,
+		width:    80,
+		height:   24,
+	}
+
+	_, err := tea.NewProgram(m, tea.WithAltScreen(), tea.WithContext(ctx)).Run()
+	return err
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return m.load()
+}
+
+// load fetches all masked emails, including deleted ones so they can be
+// toggled without another request.
+func (m *tuiModel) load() tea.Cmd {
+	return func() tea.Msg {
+		session, err := m.client.SessionContext(m.ctx)
+		if err != nil {
+			return tuiLoadedMsg{err: err}
+		}
+
+		emails, err := m.client.GetAllMaskedEmailsContext(m.ctx, session, *flagAccountID, true)
+		return tuiLoadedMsg{session: session, emails: emails, err: err}
+	}
+}
+
+// update applies the options to the masked email and fetches it again.
+func (m *tuiModel) update(email *pkg.MaskedEmail, status string, opts ...pkg.UpdateOption) tea.Cmd {
+	session := m.session
+	return func() tea.Msg {
+		_, err := m.client.UpdateMaskedEmailContext(m.ctx, session, *flagAccountID, email.ID, opts...)
+		if err != nil {
+			return tuiChangedMsg{err: err}
+		}
+
+		updated, err := m.client.GetMaskedEmailContext(m.ctx, session, *flagAccountID, email.ID)
+		return tuiChangedMsg{email: updated, status: status, err: err}
+	}
+}
+
+// create creates a masked email for the domain, using the description
+// template of the profile if any.
+func (m *tuiModel) create(domain string) tea.Cmd {
+	session := m.session
+	return func() tea.Msg {
+		description := ""
+		if activeProfile.Description != "" {
+			var err error
+			description, err = renderTemplate(flagNameDesc, activeProfile.Description, newCreateTemplateData(activeProfileName, domain, ""))
+			if err != nil {
+				return tuiChangedMsg{err: err}
+			}
+		}
+
+		created, err := m.client.CreateMaskedEmailContext(m.ctx, session, *flagAccountID, domain, description, "", true)
+		if err != nil {
+			return tuiChangedMsg{err: err}
+		}
+
+		email, err := m.client.GetMaskedEmailContext(m.ctx, session, *flagAccountID, created.ID)
+		return tuiChangedMsg{email: email, status: "created " + created.Email, err: err}
+	}
+}
+
+func copyEmail(email *pkg.MaskedEmail) tea.Cmd {
+	return func() tea.Msg {
+		if err := copyToClipboard(email.Email); err != nil {
+			return tuiStatusMsg{err: err}
+		}
+
+		return tuiStatusMsg{status: "copied " + email.Email}
+	}
+}
+
+// selected returns the masked email under the cursor, if any.
+func (m *tuiModel) selected() *pkg.MaskedEmail {
+	if m.cursor < 0 || m.cursor >= len(m.rows) {
+		return nil
+	}
+
+	return m.rows[m.cursor]
+}
+
+// refresh recomputes the shown rows, keeping the cursor on the selected
+// masked email if it's still shown.
+func (m *tuiModel) refresh() {
+	var selectedID string
+	if email := m.selected(); email != nil {
+		selectedID = email.ID
+	}
+
+	rows := make([]*pkg.MaskedEmail, 0, len(m.emails))
+	scores := map[string]int{}
+	for _, email := range m.emails {
+		if email.State == pkg.MaskedEmailStateDeleted && !m.showDeleted {
+			continue
+		}
+
+		if m.query != "" {
+			score, ok := fuzzyScore(m.query, email.Email+" "+strings.TrimSpace(email.Domain)+" "+strings.TrimSpace(email.Description))
+			if !ok {
+				continue
+			}
+			scores[email.ID] = score
+		}
+
+		rows = append(rows, email)
+	}
+
+	pkg.SortMaskedEmails(rows, tuiSortFields[m.sortIndex], m.sortDesc)
+	if m.query != "" {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return scores[rows[i].ID] > scores[rows[j].ID]
+		})
+	}
+
+	m.rows = rows
+	m.cursor = 0
+	for i, email := range rows {
+		if email.ID == selectedID {
+			m.cursor = i
+			break
+		}
+	}
+	m.scroll()
+}
+
+// visibleRows is the number of table rows fitting the window.
+func (m *tuiModel) visibleRows() int {
+	// title, table header, status and help line
+	if n := m.height - 4; n > 1 {
+		return n
+	}
+
+	return 1
+}
+
+// scroll keeps the cursor within the rows and the visible window.
+func (m *tuiModel) scroll() {
+	if m.cursor >= len(m.rows) {
+		m.cursor = len(m.rows) - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if n := m.visibleRows(); m.cursor >= m.offset+n {
+		m.offset = m.cursor - n + 1
+	}
+}
+
+// prompt switches to an input mode.
+func (m *tuiModel) prompt(mode tuiMode, prompt string, value string) tea.Cmd {
+	m.mode = mode
+	m.input.Prompt = prompt
+	m.input.SetValue(value)
+	m.input.CursorEnd()
+	m.err = nil
+	m.status = ""
+
+	return m.input.Focus()
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+		m.scroll()
+		return m, nil
+
+	case tuiLoadedMsg:
+		m.loading = false
+		m.err = msg.err
+		if msg.err == nil {
+			m.session = msg.session
+			m.emails = msg.emails
+			m.status = fmt.Sprintf("loaded %d masked emails", len(msg.emails))
+			m.refresh()
+		}
+		return m, nil
+
+	case tuiChangedMsg:
+		m.err = msg.err
+		if msg.email != nil {
+			m.status = msg.status
+			m.replace(msg.email)
+		}
+		return m, nil
+
+	case tuiStatusMsg:
+		m.status, m.err = msg.status, msg.err
+		return m, nil
+
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			return m, tea.Quit
+		}
+
+		switch m.mode {
+		case tuiModeBrowse:
+			return m.updateBrowse(msg)
+		case tuiModeConfirmDelete:
+			return m.updateConfirmDelete(msg)
+		default:
+			return m.updateInput(msg)
+		}
+	}
+
+	return m, nil
+}
+
+// replace adds or replaces the masked email with the same ID.
+func (m *tuiModel) replace(email *pkg.MaskedEmail) {
+	for i, e := range m.emails {
+		if e.ID == email.ID {
+			m.emails[i] = email
+			m.refresh()
+			return
+		}
+	}
+
+	m.emails = append(m.emails, email)
+	m.refresh()
+	for i, e := range m.rows {
+		if e.ID == email.ID {
+			m.cursor = i
+			m.scroll()
+		}
+	}
+}
+
+func (m *tuiModel) updateBrowse(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	email := m.selected()
+
+	switch msg.String() {
+	case "q", "esc":
+		if msg.String() == "esc" && m.query != "" {
+			m.query = ""
+			m.refresh()
+			return m, nil
+		}
+		return m, tea.Quit
+
+	case "up", "k":
+		m.cursor--
+	case "down", "j":
+		m.cursor++
+	case "pgup", "ctrl+b":
+		m.cursor -= m.visibleRows()
+	case "pgdown", "ctrl+f", " ":
+		m.cursor += m.visibleRows()
+	case "home", "g":
+		m.cursor = 0
+	case "end", "G":
+		m.cursor = len(m.rows) - 1
+
+	case "/":
+		return m, m.prompt(tuiModeSearch, "/", m.query)
+
+	case "s":
+		m.sortIndex = (m.sortIndex + 1) % len(tuiSortFields)
+		m.refresh()
+	case "S":
+		m.sortDesc = !m.sortDesc
+		m.refresh()
+
+	case "a":
+		m.showDeleted = !m.showDeleted
+		m.refresh()
+
+	case "r":
+		m.loading = true
+		m.status = ""
+		return m, m.load()
+
+	case "n":
+		if m.session != nil {
+			return m, m.prompt(tuiModeCreate, "new masked email for domain: ", "")
+		}
+
+	case "e", "d", "x", "E", "F", "c", "enter":
+		if email == nil || m.session == nil {
+			return m, nil
+		}
+
+		switch msg.String() {
+		case "e":
+			return m, m.update(email, "enabled "+email.Email, pkg.WithUpdateState(pkg.MaskedEmailStateEnabled))
+		case "d":
+			return m, m.update(email, "disabled "+email.Email, pkg.WithUpdateState(pkg.MaskedEmailStateDisabled))
+		case "x":
+			m.mode = tuiModeConfirmDelete
+			m.deleteID, m.deleteEmail = email.ID, email.Email
+		case "E":
+			m.editID, m.editEmail = email.ID, email.Email
+			return m, m.prompt(tuiModeEditDescription, "description: ", strings.TrimSpace(email.Description))
+		case "F":
+			m.editID, m.editEmail = email.ID, email.Email
+			return m, m.prompt(tuiModeEditDomain, "domain: ", strings.TrimSpace(email.Domain))
+		case "c", "enter":
+			return m, copyEmail(email)
+		}
+	}
+
+	m.scroll()
+	return m, nil
+}
+
+func (m *tuiModel) updateConfirmDelete(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	m.mode = tuiModeBrowse
+	email := &pkg.MaskedEmail{ID: m.deleteID, Email: m.deleteEmail}
+	m.deleteID, m.deleteEmail = "", ""
+
+	if email.ID == "" || msg.String() != "y" {
+		m.status = "delete canceled"
+		return m, nil
+	}
+
+	return m, m.update(email, "deleted "+email.Email, pkg.WithUpdateState(pkg.MaskedEmailStateDeleted))
+}
+
+func (m *tuiModel) updateInput(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	mode := m.mode
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.mode = tuiModeBrowse
+		m.input.Blur()
+		m.editID, m.editEmail = "", ""
+		if mode == tuiModeSearch {
+			m.query = ""
+			m.refresh()
+		}
+		return m, nil
+
+	case tea.KeyEnter:
+		m.mode = tuiModeBrowse
+		m.input.Blur()
+		value := strings.TrimSpace(m.input.Value())
+
+		email := &pkg.MaskedEmail{ID: m.editID, Email: m.editEmail}
+		m.editID, m.editEmail = "", ""
+
+		switch mode {
+		case tuiModeEditDescription:
+			if email.ID != "" {
+				return m, m.update(email, "updated "+email.Email, pkg.WithUpdateDescription(value))
+			}
+		case tuiModeEditDomain:
+			if email.ID != "" {
+				return m, m.update(email, "updated "+email.Email, pkg.WithUpdateDomain(value))
+			}
+		case tuiModeCreate:
+			return m, m.create(value)
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.input, cmd = m.input.Update(msg)
+
+	// search as you type
+	if mode == tuiModeSearch && m.input.Value() != m.query {
+		m.query = m.input.Value()
+		m.refresh()
+	}
+
+	return m, cmd
+}
+
+func (m *tuiModel) View() string {
+	b := &strings.Builder{}
+
+	title := fmt.Sprintf("%s  %d/%d masked emails  sort: %s", defaultAppname, len(m.rows), len(m.emails), tuiSortFields[m.sortIndex])
+	if m.sortDesc {
+		title += " (desc)"
+	}
+	if m.query != "" {
+		title += "  search: " + m.query
+	}
+	if m.showDeleted {
+		title += "  +deleted"
+	}
+	b.WriteString(tuiHeaderStyle.Render(fit(title, m.width)) + "\n")
+
+	widths := m.columnWidths()
+	b.WriteString(tuiHeaderStyle.Render(m.row(widths, "Masked Email", "For Domain", "Description", "State", "Created")) + "\n")
+
+	n := m.visibleRows()
+	for i := m.offset; i < m.offset+n; i++ {
+		if i >= len(m.rows) {
+			b.WriteString("\n")
+			continue
+		}
+
+		email := m.rows[i]
+		created := ""
+		if !email.CreatedAt.IsZero() {
+			created = out.inZone(email.CreatedAt).Format("2006-01-02")
+		}
+
+		line := m.row(widths, email.Email, strings.TrimSpace(email.Domain), strings.TrimSpace(email.Description), string(email.State), created)
+		if i == m.cursor {
+			line = tuiSelectedStyle.Render(line)
+		}
+		b.WriteString(line + "\n")
+	}
+
+	switch {
+	case m.mode == tuiModeConfirmDelete:
+		b.WriteString(fmt.Sprintf("delete %s? (y/n)", m.deleteEmail))
+	case m.mode != tuiModeBrowse:
+		b.WriteString(m.input.View())
+	case m.loading:
+		b.WriteString(tuiStatusStyle.Render("loading..."))
+	case m.err != nil:
+		b.WriteString(tuiErrorStyle.Render(fit("error: "+m.err.Error(), m.width)))
+	default:
+		b.WriteString(tuiStatusStyle.Render(fit(m.status, m.width)))
+	}
+	b.WriteString("\n" + tuiStatusStyle.Render(fit(tuiHelp, m.width)))
+
+	return b.String()
+}
+
+// columnWidths splits the window width between the columns, giving the
+// description the remaining space.
+func (m *tuiModel) columnWidths() []int {
+	widths := []int{32, 24, 0, 9, 10}
+
+	rest := m.width - len(widths) + 1
+	for _, w := range widths {
+		rest -= w
+	}
+	if rest < 10 {
+		rest = 10
+	}
+	widths[2] = rest
+
+	return widths
+}
+
+func (m *tuiModel) row(widths []int, values ...string) string {
+	cells := make([]string, len(values))
+	for i, v := range values {
+		cells[i] = runewidth.FillRight(runewidth.Truncate(v, widths[i], "…"), widths[i])
+	}
+
+	return fit(strings.Join(cells, " "), m.width)
+}
+
+// fit truncates s to the window width.
+func fit(s string, width int) string {
+	return runewidth.Truncate(s, width, "")
+}
+
+// fuzzyScore matches the characters of pattern in order, case-insensitively,
+// anywhere in s. Consecutive matches and matches at the start of words score
+// higher.
+func fuzzyScore(pattern string, s string) (int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	if len(p) == 0 {
+		return 0, true
+	}
+
+	score, j := 0, 0
+	prevMatched := false
+	prev := ' '
+	for _, r := range strings.ToLower(s) {
+		if j < len(p) && r == p[j] {
+			score++
+			if prevMatched {
+				score += 2
+			}
+			if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+				score += 3
+			}
+			j++
+			prevMatched = true
+		} else {
+			prevMatched = false
+		}
+		prev = r
+	}
+
+	return score, j == len(p)
+}